@@ -0,0 +1,116 @@
+package fio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/setlog/fio/fsi"
+)
+
+var tempFileCounter uint64
+
+// createTempFile exclusively creates, opens for reading and writing, and locks a new file in the directory dir,
+// whose name is derived from baseName so that it can be related to the file it replaces.
+func createTempFile(o *options, dir, baseName string, perm fs.FileMode) (fsi.File, string, error) {
+	for attempt := 0; ; attempt++ {
+		tempFilePath := filepath.Join(dir, fmt.Sprintf(".%s.%d-%d.tmp", baseName, os.Getpid(), atomic.AddUint64(&tempFileCounter, 1)))
+		file, err := openFile(o, tempFilePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil {
+			return file, tempFilePath, nil
+		}
+		if !errors.Is(err, os.ErrExist) || attempt == 100 {
+			return nil, "", err
+		}
+	}
+}
+
+// writeFileAtomic writes all data read from reader to a locked temporary file in the directory
+// of filePath, syncs it and renames it over filePath. This way, readers either see the previous
+// content of filePath or the new content, but never a partial write.
+//
+// While doing so, it holds a write lock on the file it replaces, if any, and preserves its permissions.
+// If filePath is a symbolic link, the file it points to is replaced. Other files than regular ones are
+// not replaced; writeFileAtomic fails for them.
+func writeFileAtomic(o *options, filePath string, reader io.Reader, perm fs.FileMode) (n int64, retErr error) {
+	filePath, info, err := resolveSymlinks(o, filePath)
+	if err != nil {
+		return 0, err
+	}
+	var target fsi.File
+	if info != nil {
+		if !info.Mode().IsRegular() {
+			return 0, fmt.Errorf("replace %v: not a regular file", info.Mode().Type())
+		}
+		target, err = openFile(o, filePath, os.O_WRONLY, 0)
+		if err == nil {
+			defer closeFile(o, target)
+			targetInfo, err := target.Stat()
+			if err != nil {
+				return 0, err
+			}
+			perm = targetInfo.Mode()
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+
+	dir, baseName := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	temp, tempFilePath, err := createTempFile(o, dir, baseName, perm)
+	if err != nil {
+		return 0, fmt.Errorf("create temporary file: %w", err)
+	}
+	renamed := false
+	defer func() {
+		closeFile(o, temp)
+		if !renamed {
+			if remErr := o.fs.Remove(tempFilePath); remErr != nil && !os.IsNotExist(remErr) {
+				retErr = fmt.Errorf("%w. Then: %v", retErr, remErr)
+			}
+		}
+	}()
+	if target != nil {
+		if err = o.fs.Chmod(tempFilePath, perm); err != nil {
+			return 0, err
+		}
+	}
+	src, isSourceFile := reader.(*sourceFile)
+	reader, hash := hashingReader(o, reader)
+	if n, err = copyData(o, temp, reader); err != nil {
+		return n, err
+	}
+	if err = verifyChecksum(o, temp, n, hash); err != nil {
+		return n, err
+	}
+	if isSourceFile {
+		if err = preserveMetadata(o, tempFilePath, src); err != nil {
+			return n, err
+		}
+	}
+	if err = temp.Sync(); err != nil {
+		return n, err
+	}
+	if err = o.fs.Rename(tempFilePath, filePath); err != nil {
+		return n, err
+	}
+	renamed = true
+	return n, syncDir(o, dir)
+}
+
+// syncDir flushes the directory entries of dir to disk, making renames and
+// newly created files in it durable.
+func syncDir(o *options, dir string) error {
+	file, err := o.fs.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
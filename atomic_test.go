@@ -0,0 +1,193 @@
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicReplacesContentAndKeepsPermissions(t *testing.T) {
+	filePath := createTestFile(t)
+	if err := os.Chmod(filePath, 0604); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	WriteFileAtomic(filePath, []byte("Hello Gopher"))
+
+	expectFileContent(t, filePath, "Hello Gopher")
+	expectNoTempFiles(t, filepath.Dir(filePath))
+	if info, err := os.Stat(filePath); err != nil || info.Mode().Perm() != 0604 {
+		t.Fatalf("expected permissions 0604, got %v (%v)", info.Mode().Perm(), err)
+	}
+	if data, err := io.ReadAll(reader); err != nil || string(data) != testData {
+		t.Fatalf("expected previously opened descriptor to still read %q, got %q (%v)", testData, data, err)
+	}
+}
+
+func TestWriteFileAtomicKeepsContentOnFailure(t *testing.T) {
+	filePath := createTestFile(t)
+
+	_, err := TryWriteFileWithReaderAtomic(filePath, io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	if !errors.Is(err, errFailingReader) {
+		t.Fatalf("expected errFailingReader, got %v", err)
+	}
+	expectFileContent(t, filePath, testData)
+	expectNoTempFiles(t, filepath.Dir(filePath))
+}
+
+func TestWriteFileAtomicRespectsLocks(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_RDONLY, 0660, ofd)
+	defer file.Close()
+
+	if err := TryWriteFileAtomic(filePath, []byte("Hello Gopher"), ofd); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	expectFileContent(t, filePath, testData)
+	expectNoTempFiles(t, filepath.Dir(filePath))
+}
+
+func TestWriteFilePreserveOnFailureKeepsContent(t *testing.T) {
+	filePath := createTestFile(t)
+
+	_, err := TryWriteFileWithReader(filePath, io.MultiReader(strings.NewReader("partial"), failingReader{}), WithPreserveOnFailure())
+	if !errors.Is(err, errFailingReader) {
+		t.Fatalf("expected errFailingReader, got %v", err)
+	}
+	expectFileContent(t, filePath, testData)
+	expectNoTempFiles(t, filepath.Dir(filePath))
+}
+
+func TestWriteFilePreserveOnFailureKeepsInode(t *testing.T) {
+	filePath := createTestFile(t)
+	before, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFile(filePath, []byte("Hello Gopher"), WithPreserveOnFailure())
+
+	expectFileContent(t, filePath, "Hello Gopher")
+	expectNoTempFiles(t, filepath.Dir(filePath))
+	if after, err := os.Stat(filePath); err != nil || !os.SameFile(before, after) {
+		t.Fatalf("expected %s to be overwritten in place (%v)", filePath, err)
+	}
+}
+
+func TestWriteFileRemovesOnlyCreatedFilesOnFailure(t *testing.T) {
+	filePath := createTestFile(t)
+
+	if _, err := TryWriteFileWithReader(filePath, failingReader{}); !errors.Is(err, errFailingReader) {
+		t.Fatalf("expected errFailingReader, got %v", err)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Fatalf("expected existing file to be kept, got %v", err)
+	}
+
+	newFilePath := filepath.Join(filepath.Dir(filePath), "new.txt")
+	if _, err := TryWriteFileWithReader(newFilePath, failingReader{}); !errors.Is(err, errFailingReader) {
+		t.Fatalf("expected errFailingReader, got %v", err)
+	}
+	if _, err := os.Stat(newFilePath); !os.IsNotExist(err) {
+		t.Fatalf("expected created file to be removed, got %v", err)
+	}
+}
+
+func TestWriteFileCreatesTargetOfDanglingSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linkPath, targetPath := filepath.Join(dir, "link"), filepath.Join(dir, "target")
+	if err := os.Symlink(targetPath, linkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFile(linkPath, []byte(testData))
+
+	expectFileContent(t, targetPath, testData)
+}
+
+func TestWriteFileAtomicReplacesTargetOfSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linkPath, targetPath := filepath.Join(dir, "link"), filepath.Join(dir, "target")
+	if err := os.WriteFile(targetPath, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target", linkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	WriteFileAtomic(linkPath, []byte(testData))
+
+	expectFileContent(t, targetPath, testData)
+	if info, err := os.Lstat(linkPath); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected symbolic link to be kept, got %v and %v", info, err)
+	}
+	if info, err := os.Stat(targetPath); err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("expected permissions of target to be kept, got %v and %v", info, err)
+	}
+	expectNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicRejectsFifo(t *testing.T) {
+	fifoPath := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(fifoPath, 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TryWriteFileAtomic(fifoPath, []byte(testData)); err == nil {
+		t.Fatal("expected error")
+	}
+	if info, err := os.Lstat(fifoPath); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("expected FIFO to be kept, got %v and %v", info, err)
+	}
+}
+
+func TestCopyFileWithAtomicWrite(t *testing.T) {
+	fromFilePath := createTestFile(t)
+	toFilePath := filepath.Join(filepath.Dir(fromFilePath), testDestinationFileName)
+
+	CopyFile(fromFilePath, toFilePath, WithAtomicWrite())
+
+	expectFileContent(t, toFilePath, testData)
+	expectNoTempFiles(t, filepath.Dir(toFilePath))
+}
+
+var errFailingReader = errors.New("failing reader")
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errFailingReader
+}
+
+func expectFileContent(t *testing.T, filePath string, content string) {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Fatalf("expected content %q, got %q", content, data)
+	}
+}
+
+func expectNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Fatalf("expected no temporary files, got %v", matches)
+	}
+}
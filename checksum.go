@@ -0,0 +1,14 @@
+package fio
+
+import (
+	"hash"
+	"hash/crc32"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C returns a new hash computing the CRC-32 checksum using the Castagnoli polynomial,
+// which is accelerated by the CPU on most platforms. It can be passed to WithChecksum.
+func CRC32C() hash.Hash {
+	return crc32.New(crc32cTable)
+}
@@ -0,0 +1,53 @@
+package fio
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// hashingReader returns a reader which feeds all data read from reader to a new hash created with o.checksum,
+// and that hash. If o.checksum is nil, it returns reader itself and nil.
+func hashingReader(o *options, reader io.Reader) (io.Reader, hash.Hash) {
+	if o.checksum == nil {
+		return reader, nil
+	}
+	h := o.checksum()
+	return io.TeeReader(reader, h), h
+}
+
+// verifyChecksum reads back the first n bytes of file and compares their digest to the digest of the data
+// written, which h has been fed with. On success, the digest is stored in o.result.Digest. If h is nil, it does nothing.
+func verifyChecksum(o *options, file fsi.File, n int64, h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	written := h.Sum(nil)
+	h.Reset()
+	if _, err := io.Copy(h, trackReader(io.NewSectionReader(file, 0, n), o.ctx, nil)); err != nil {
+		return fmt.Errorf("read back: %w", err)
+	}
+	if read := h.Sum(nil); !bytes.Equal(read, written) {
+		return fmt.Errorf("%w: wrote %x, read back %x", ErrChecksumMismatch, written, read)
+	}
+	o.result.Digest = written
+	return nil
+}
+
+// digestFile stores the digest of the first n bytes of file, computed with a hash created by o.checksum,
+// in o.result.Digest. This way, a digest is reported even if no data was written. If o.checksum is nil,
+// it does nothing.
+func digestFile(o *options, file fsi.File, n int64) error {
+	if o.checksum == nil {
+		return nil
+	}
+	h := o.checksum()
+	if _, err := io.Copy(h, trackReader(io.NewSectionReader(file, 0, n), o.ctx, nil)); err != nil {
+		return fmt.Errorf("compute digest: %w", err)
+	}
+	o.result.Digest = h.Sum(nil)
+	return nil
+}
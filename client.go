@@ -0,0 +1,447 @@
+package fio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/setlog/panik"
+)
+
+// Client provides the functions of this package as methods which apply a fixed set of options
+// to every call, such as the file system to access (WithFileSystem), the logger (WithLogger),
+// the permissions of created files (WithPerm), the Locker (WithLocker) and the durability
+// (WithDurability). Options passed to a method are applied after those of the Client.
+//
+// Different parts of a program can thereby use different settings without touching the package-level
+// defaults, and tests can run in parallel against their own file systems. The package-level functions
+// use a Client without options, which follows the package-level defaults at the time of each call.
+//
+// A Client is safe for concurrent use. WithResult only takes effect when passed to a method,
+// since calls running concurrently cannot share a Result; NewClient ignores it.
+type Client struct {
+	opts []Option
+}
+
+// NewClient returns a Client which applies opts to all of its calls.
+func NewClient(opts ...Option) *Client {
+	return &Client{opts: append([]Option(nil), opts...)}
+}
+
+var defaultClient = &Client{}
+
+func (c *Client) newOptions(opts []Option) *options {
+	return newOptions(append(append(c.opts[:len(c.opts):len(c.opts)], withoutResult), opts...))
+}
+
+// withoutResult discards a Result passed to NewClient.
+func withoutResult(o *options) {
+	o.result = nil
+}
+
+func (c *Client) newContextOptions(ctx context.Context, opts []Option) *options {
+	o := c.newOptions(opts)
+	o.ctx = ctx
+	return o
+}
+
+// OpenFile is like the function OpenFile, but uses the settings of c.
+func (c *Client) OpenFile(filePath string, flag int, perm fs.FileMode, opts ...Option) *LockedFile {
+	file, err := c.TryOpenFile(filePath, flag, perm, opts...)
+	panik.OnError(err)
+	return file
+}
+
+// OpenFileContext is like the function OpenFileContext, but uses the settings of c.
+func (c *Client) OpenFileContext(ctx context.Context, filePath string, flag int, perm fs.FileMode, opts ...Option) *LockedFile {
+	file, err := c.TryOpenFileContext(ctx, filePath, flag, perm, opts...)
+	panik.OnError(err)
+	return file
+}
+
+// ReadFile is like the function ReadFile, but uses the settings of c.
+func (c *Client) ReadFile(filePath string, opts ...Option) []byte {
+	data, err := c.TryReadFile(filePath, opts...)
+	panik.OnError(err)
+	return data
+}
+
+// ReadFileContext is like the function ReadFileContext, but uses the settings of c.
+func (c *Client) ReadFileContext(ctx context.Context, filePath string, opts ...Option) []byte {
+	data, err := c.TryReadFileContext(ctx, filePath, opts...)
+	panik.OnError(err)
+	return data
+}
+
+// MoveFile is like the function MoveFile, but uses the settings of c.
+func (c *Client) MoveFile(fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := c.TryMoveFile(fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// MoveFileContext is like the function MoveFileContext, but uses the settings of c.
+func (c *Client) MoveFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := c.TryMoveFileContext(ctx, fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// RenameFile is like the function RenameFile, but uses the settings of c.
+func (c *Client) RenameFile(fromFilePath, toFilePath string, opts ...Option) {
+	panik.OnError(c.TryRenameFile(fromFilePath, toFilePath, opts...))
+}
+
+// CopyFile is like the function CopyFile, but uses the settings of c.
+func (c *Client) CopyFile(fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := c.TryCopyFile(fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// CopyFileContext is like the function CopyFileContext, but uses the settings of c.
+func (c *Client) CopyFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := c.TryCopyFileContext(ctx, fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// WriteFile is like the function WriteFile, but uses the settings of c.
+func (c *Client) WriteFile(filePath string, data []byte, opts ...Option) {
+	panik.OnError(c.TryWriteFile(filePath, data, opts...))
+}
+
+// WriteFileContext is like the function WriteFileContext, but uses the settings of c.
+func (c *Client) WriteFileContext(ctx context.Context, filePath string, data []byte, opts ...Option) {
+	panik.OnError(c.TryWriteFileContext(ctx, filePath, data, opts...))
+}
+
+// WriteFilePerm is like the function WriteFilePerm, but uses the settings of c.
+func (c *Client) WriteFilePerm(filePath string, data []byte, perm fs.FileMode, opts ...Option) {
+	panik.OnError(c.TryWriteFilePerm(filePath, data, perm, opts...))
+}
+
+// WriteFileWithReader is like the function WriteFileWithReader, but uses the settings of c.
+func (c *Client) WriteFileWithReader(filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := c.TryWriteFileWithReader(filePath, reader, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// WriteFileWithReaderContext is like the function WriteFileWithReaderContext, but uses the settings of c.
+func (c *Client) WriteFileWithReaderContext(ctx context.Context, filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := c.TryWriteFileWithReaderContext(ctx, filePath, reader, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// WriteFileWithReaderPerm is like the function WriteFileWithReaderPerm, but uses the settings of c.
+func (c *Client) WriteFileWithReaderPerm(filePath string, reader io.Reader, perm os.FileMode, opts ...Option) int64 {
+	n, err := c.TryWriteFileWithReaderPerm(filePath, reader, perm, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// WriteFileAtomic is like the function WriteFileAtomic, but uses the settings of c.
+func (c *Client) WriteFileAtomic(filePath string, data []byte, opts ...Option) {
+	panik.OnError(c.TryWriteFileAtomic(filePath, data, opts...))
+}
+
+// WriteFileWithReaderAtomic is like the function WriteFileWithReaderAtomic, but uses the settings of c.
+func (c *Client) WriteFileWithReaderAtomic(filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := c.TryWriteFileWithReaderAtomic(filePath, reader, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// ReadRange is like the function ReadRange, but uses the settings of c.
+func (c *Client) ReadRange(filePath string, offset int64, n int, opts ...Option) []byte {
+	data, err := c.TryReadRange(filePath, offset, n, opts...)
+	panik.OnError(err)
+	return data
+}
+
+// WriteRange is like the function WriteRange, but uses the settings of c.
+func (c *Client) WriteRange(filePath string, offset int64, data []byte, opts ...Option) {
+	panik.OnError(c.TryWriteRange(filePath, offset, data, opts...))
+}
+
+// IsLocked is like the function IsLocked, but uses the settings of c.
+func (c *Client) IsLocked(filePath string, opts ...Option) bool {
+	locked, err := c.TryIsLocked(filePath, opts...)
+	panik.OnError(err)
+	return locked
+}
+
+// LockHolder is like the function LockHolder, but uses the settings of c.
+func (c *Client) LockHolder(filePath string, opts ...Option) *LockInfo {
+	holder, err := c.TryLockHolder(filePath, opts...)
+	panik.OnError(err)
+	return holder
+}
+
+// WaitUntilUnlocked is like the function WaitUntilUnlocked, but uses the settings of c.
+func (c *Client) WaitUntilUnlocked(ctx context.Context, filePath string, opts ...Option) {
+	panik.OnError(c.TryWaitUntilUnlocked(ctx, filePath, opts...))
+}
+
+// WaitUntilStable is like the function WaitUntilStable, but uses the settings of c.
+func (c *Client) WaitUntilStable(ctx context.Context, filePath string, quietPeriod time.Duration, opts ...Option) {
+	panik.OnError(c.TryWaitUntilStable(ctx, filePath, quietPeriod, opts...))
+}
+
+// ListXattr is like the function ListXattr, but uses the settings of c.
+func (c *Client) ListXattr(filePath string, opts ...Option) []string {
+	names, err := c.TryListXattr(filePath, opts...)
+	panik.OnError(err)
+	return names
+}
+
+// GetXattr is like the function GetXattr, but uses the settings of c.
+func (c *Client) GetXattr(filePath, name string, opts ...Option) []byte {
+	value, err := c.TryGetXattr(filePath, name, opts...)
+	panik.OnError(err)
+	return value
+}
+
+// SetXattr is like the function SetXattr, but uses the settings of c.
+func (c *Client) SetXattr(filePath, name string, value []byte, opts ...Option) {
+	panik.OnError(c.TrySetXattr(filePath, name, value, opts...))
+}
+
+// RemoveXattr is like the function RemoveXattr, but uses the settings of c.
+func (c *Client) RemoveXattr(filePath, name string, opts ...Option) {
+	panik.OnError(c.TryRemoveXattr(filePath, name, opts...))
+}
+
+// RemoveFile is like the function RemoveFile, but uses the settings of c.
+func (c *Client) RemoveFile(filePath string, opts ...Option) bool {
+	removed, err := c.TryRemoveFile(filePath, opts...)
+	panik.OnError(err)
+	return removed
+}
+
+// TryOpenFile is like the function TryOpenFile, but uses the settings of c.
+func (c *Client) TryOpenFile(filePath string, flag int, perm fs.FileMode, opts ...Option) (*LockedFile, error) {
+	return tryOpenFile(c.newOptions(opts), filePath, flag, perm)
+}
+
+// TryOpenFileContext is like the function TryOpenFileContext, but uses the settings of c.
+func (c *Client) TryOpenFileContext(ctx context.Context, filePath string, flag int, perm fs.FileMode, opts ...Option) (*LockedFile, error) {
+	return tryOpenFile(c.newContextOptions(ctx, opts), filePath, flag, perm)
+}
+
+// TryReadFile is like the function TryReadFile, but uses the settings of c.
+func (c *Client) TryReadFile(filePath string, opts ...Option) ([]byte, error) {
+	return tryReadFile(c.newOptions(opts), filePath)
+}
+
+// TryReadFileContext is like the function TryReadFileContext, but uses the settings of c.
+func (c *Client) TryReadFileContext(ctx context.Context, filePath string, opts ...Option) ([]byte, error) {
+	return tryReadFile(c.newContextOptions(ctx, opts), filePath)
+}
+
+// TryMoveFile is like the function TryMoveFile, but uses the settings of c.
+func (c *Client) TryMoveFile(fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return tryMoveFile(c.newOptions(opts), fromFilePath, toFilePath)
+}
+
+// TryMoveFileContext is like the function TryMoveFileContext, but uses the settings of c.
+func (c *Client) TryMoveFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return tryMoveFile(c.newContextOptions(ctx, opts), fromFilePath, toFilePath)
+}
+
+// TryRenameFile is like the function TryRenameFile, but uses the settings of c.
+func (c *Client) TryRenameFile(fromFilePath, toFilePath string, opts ...Option) error {
+	if err := c.newOptions(opts).fs.Rename(fromFilePath, toFilePath); err != nil {
+		return newOpError("rename", fromFilePath, toFilePath, err)
+	}
+	return nil
+}
+
+// TryCopyFile is like the function TryCopyFile, but uses the settings of c.
+func (c *Client) TryCopyFile(fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return tryCopyFile(c.newOptions(opts), fromFilePath, toFilePath)
+}
+
+// TryCopyFileContext is like the function TryCopyFileContext, but uses the settings of c.
+func (c *Client) TryCopyFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return tryCopyFile(c.newContextOptions(ctx, opts), fromFilePath, toFilePath)
+}
+
+// TryWriteFile is like the function TryWriteFile, but uses the settings of c.
+func (c *Client) TryWriteFile(filePath string, data []byte, opts ...Option) error {
+	o := c.newOptions(opts)
+	_, err := tryWriteFile(o, filePath, bytes.NewReader(data), o.perm)
+	return err
+}
+
+// TryWriteFileContext is like the function TryWriteFileContext, but uses the settings of c.
+func (c *Client) TryWriteFileContext(ctx context.Context, filePath string, data []byte, opts ...Option) error {
+	o := c.newContextOptions(ctx, opts)
+	_, err := tryWriteFile(o, filePath, bytes.NewReader(data), o.perm)
+	return err
+}
+
+// TryWriteFilePerm is like the function TryWriteFilePerm, but uses the settings of c.
+func (c *Client) TryWriteFilePerm(filePath string, data []byte, perm fs.FileMode, opts ...Option) error {
+	_, err := tryWriteFile(c.newOptions(opts), filePath, bytes.NewReader(data), perm)
+	return err
+}
+
+// TryWriteFileWithReader is like the function TryWriteFileWithReader, but uses the settings of c.
+func (c *Client) TryWriteFileWithReader(filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	o := c.newOptions(opts)
+	return tryWriteFile(o, filePath, reader, o.perm)
+}
+
+// TryWriteFileWithReaderContext is like the function TryWriteFileWithReaderContext, but uses the settings of c.
+func (c *Client) TryWriteFileWithReaderContext(ctx context.Context, filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	o := c.newContextOptions(ctx, opts)
+	return tryWriteFile(o, filePath, reader, o.perm)
+}
+
+// TryWriteFileWithReaderPerm is like the function TryWriteFileWithReaderPerm, but uses the settings of c.
+func (c *Client) TryWriteFileWithReaderPerm(filePath string, reader io.Reader, perm os.FileMode, opts ...Option) (int64, error) {
+	return tryWriteFile(c.newOptions(opts), filePath, reader, perm)
+}
+
+// TryWriteFileAtomic is like the function TryWriteFileAtomic, but uses the settings of c.
+func (c *Client) TryWriteFileAtomic(filePath string, data []byte, opts ...Option) error {
+	o := c.newOptions(opts)
+	o.atomic = true
+	_, err := tryWriteFile(o, filePath, bytes.NewReader(data), o.perm)
+	return err
+}
+
+// TryWriteFileWithReaderAtomic is like the function TryWriteFileWithReaderAtomic, but uses the settings of c.
+func (c *Client) TryWriteFileWithReaderAtomic(filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	o := c.newOptions(opts)
+	o.atomic = true
+	return tryWriteFile(o, filePath, reader, o.perm)
+}
+
+// TryReadRange is like the function TryReadRange, but uses the settings of c.
+func (c *Client) TryReadRange(filePath string, offset int64, n int, opts ...Option) ([]byte, error) {
+	o := c.newOptions(opts)
+	data, err := readRange(o, filePath, offset, n)
+	if err != nil {
+		return nil, newOpError("read range", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Read %d bytes at offset %d of '%s'.", len(data), offset, filePath)
+	}
+	return data, nil
+}
+
+// TryWriteRange is like the function TryWriteRange, but uses the settings of c.
+func (c *Client) TryWriteRange(filePath string, offset int64, data []byte, opts ...Option) error {
+	o := c.newOptions(opts)
+	if err := writeRange(o, filePath, offset, data); err != nil {
+		return newOpError("write range", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Wrote %d bytes at offset %d of '%s'.", len(data), offset, filePath)
+	}
+	return nil
+}
+
+// TryIsLocked is like the function TryIsLocked, but uses the settings of c.
+func (c *Client) TryIsLocked(filePath string, opts ...Option) (bool, error) {
+	holder, err := c.TryLockHolder(filePath, opts...)
+	return holder != nil, err
+}
+
+// TryLockHolder is like the function TryLockHolder, but uses the settings of c.
+func (c *Client) TryLockHolder(filePath string, opts ...Option) (*LockInfo, error) {
+	holder, err := lockHolder(c.newOptions(opts), filePath)
+	if err != nil {
+		return nil, newOpError("query lock", filePath, "", err)
+	}
+	return holder, nil
+}
+
+// TryWaitUntilUnlocked is like the function TryWaitUntilUnlocked, but uses the settings of c.
+func (c *Client) TryWaitUntilUnlocked(ctx context.Context, filePath string, opts ...Option) error {
+	if err := waitUntilUnlocked(ctx, c.newOptions(opts), filePath); err != nil {
+		return newOpError("wait for unlock", filePath, "", err)
+	}
+	return nil
+}
+
+// TryWaitUntilStable is like the function TryWaitUntilStable, but uses the settings of c.
+func (c *Client) TryWaitUntilStable(ctx context.Context, filePath string, quietPeriod time.Duration, opts ...Option) error {
+	if err := waitUntilStable(ctx, c.newOptions(opts), filePath, quietPeriod); err != nil {
+		return newOpError("wait for stability", filePath, "", err)
+	}
+	return nil
+}
+
+// TryListXattr is like the function TryListXattr, but uses the settings of c.
+func (c *Client) TryListXattr(filePath string, opts ...Option) ([]string, error) {
+	o := c.newOptions(opts)
+	names, err := listXattrsLocked(o, filePath)
+	if err != nil {
+		return nil, newOpError("list extended attributes", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Listed extended attributes of '%s'.", filePath)
+	}
+	return names, nil
+}
+
+// TryGetXattr is like the function TryGetXattr, but uses the settings of c.
+func (c *Client) TryGetXattr(filePath, name string, opts ...Option) ([]byte, error) {
+	o := c.newOptions(opts)
+	value, err := getXattrLocked(o, filePath, name)
+	if err != nil {
+		return nil, newOpError("get extended attribute "+name, filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Read extended attribute '%s' of '%s'.", name, filePath)
+	}
+	return value, nil
+}
+
+// TrySetXattr is like the function TrySetXattr, but uses the settings of c.
+func (c *Client) TrySetXattr(filePath, name string, value []byte, opts ...Option) error {
+	o := c.newOptions(opts)
+	if err := setXattrLocked(o, filePath, name, value); err != nil {
+		return newOpError("set extended attribute "+name, filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Set extended attribute '%s' of '%s'.", name, filePath)
+	}
+	return nil
+}
+
+// TryRemoveXattr is like the function TryRemoveXattr, but uses the settings of c.
+func (c *Client) TryRemoveXattr(filePath, name string, opts ...Option) error {
+	o := c.newOptions(opts)
+	if err := removeXattrLocked(o, filePath, name); err != nil {
+		return newOpError("remove extended attribute "+name, filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Removed extended attribute '%s' of '%s'.", name, filePath)
+	}
+	return nil
+}
+
+// TryRemoveFile is like the function TryRemoveFile, but uses the settings of c.
+func (c *Client) TryRemoveFile(filePath string, opts ...Option) (bool, error) {
+	o := c.newOptions(opts)
+	err := o.fs.Remove(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, newOpError("remove", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Removed '%s'.", filePath)
+	}
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package fio
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/setlog/fio/mock"
+)
+
+func TestClientWriteFile(t *testing.T) {
+	for _, perm := range []os.FileMode{0600, 0644} {
+		perm := perm
+		t.Run(perm.String(), func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			fsMock := mock.NewMockFileSystem(ctrl)
+			fileMock := mock.NewMockFile(ctrl)
+			var logs bytes.Buffer
+
+			fileMock.EXPECT().Fd().Return(uintptr(3)).AnyTimes()
+			gomock.InOrder(
+				fsMock.EXPECT().OpenFile(testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm).Return(fileMock, nil),
+				fsMock.EXPECT().FcntlFlock(uintptr(3), syscall.F_SETLK, gomock.Eq(wrLock())).Return(nil),
+				expectWrite(fsMock, fileMock, []byte(testData)),
+				fileMock.EXPECT().Close(),
+			)
+
+			client := NewClient(WithFileSystem(fsMock), WithPerm(perm), WithLogger(log.New(&logs, "", 0)))
+			client.WriteFile(testDestinationFileName, []byte(testData))
+			if got := logs.String(); got != "Wrote 'bar'.\n" {
+				t.Fatalf("unexpected logs %q", got)
+			}
+		})
+	}
+}
+
+func TestClientOptionsCanBeOverridden(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	fsMock := mock.NewMockFileSystem(ctrl)
+	fileMock := mock.NewMockFile(ctrl)
+	var logs bytes.Buffer
+
+	fileMock.EXPECT().Fd().Return(uintptr(3)).AnyTimes()
+	gomock.InOrder(
+		fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(uintptr(3), syscall.F_SETLK, gomock.Eq(rdLock())).Return(nil),
+		expectRead(fsMock, fileMock, []byte(testData)),
+		fileMock.EXPECT().Close(),
+	)
+
+	client := NewClient(WithFileSystem(fsMock), WithLogger(log.New(&logs, "", 0)))
+	data := client.ReadFile(testSourceFileName, WithLogger(nil))
+	if string(data) != testData {
+		t.Fatalf("expected %q, got %q", testData, data)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected no logs, got %q", logs.String())
+	}
+}
+
+func TestNewClientCopiesOptions(t *testing.T) {
+	opts := make([]Option, 1, 2)
+	opts[0] = WithPerm(0600)
+	client := NewClient(opts...)
+	opts[0] = WithPerm(0644)
+	client.newOptions([]Option{WithPerm(0640)})
+	if perm := client.newOptions(nil).perm; perm != 0600 {
+		t.Fatalf("expected perm 0600, got %v", perm)
+	}
+}
+
+func TestClientIgnoresResult(t *testing.T) {
+	var shared, own Result
+	client := NewClient(WithResult(&shared))
+	if o := client.newOptions(nil); o.result == &shared {
+		t.Fatal("expected Result of NewClient to be ignored")
+	}
+	if o := client.newOptions([]Option{WithResult(&own)}); o.result != &own {
+		t.Fatal("expected Result of the call to be used")
+	}
+}
+
+func TestClientRemoveFileUsesCallOptions(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	fsMock := mock.NewMockFileSystem(ctrl)
+
+	fsMock.EXPECT().Rename(testSourceFileName, testDestinationFileName).Return(nil)
+	fsMock.EXPECT().Remove(testDestinationFileName).Return(nil)
+
+	client := NewClient(WithLogger(nil))
+	client.RenameFile(testSourceFileName, testDestinationFileName, WithFileSystem(fsMock))
+	if !client.RemoveFile(testDestinationFileName, WithFileSystem(fsMock)) {
+		t.Fatal("expected file to be removed")
+	}
+}
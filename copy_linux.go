@@ -0,0 +1,247 @@
+package fio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// copyChunkSize is the maximum amount of bytes transferred by a single system call of the copy engine.
+const copyChunkSize = 8 << 20
+
+// seekData and seekHole are the lseek(2) whence values which find the next data segment and hole of a file.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// sourceFile is a file opened and locked by copyFile or moveFile, positioned at its start.
+// Passing it to writeFile allows the data to be transferred by the kernel.
+type sourceFile struct {
+	fsi.File
+	path string
+	info fs.FileInfo
+	size int64
+	// sparse is true if fewer blocks are allocated for the file than its size requires.
+	sparse bool
+}
+
+func newSourceFile(file fsi.File, filePath string, info fs.FileInfo) *sourceFile {
+	src := &sourceFile{File: file, path: filePath, info: info, size: info.Size()}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		src.sparse = int64(stat.Blocks)*512 < stat.Size
+	}
+	return src
+}
+
+// copyData writes all data read from reader to dst and stores the method used in o.result.Copy.
+// If reader is a *sourceFile, the data is transferred with the first method supported by the file
+// systems involved, in order: cloning, copy_file_range(2), sendfile(2) and buffered copying.
+// The holes of a sparse source are recreated in dst unless o.denseCopy is set.
+func copyData(o *options, dst fsi.File, reader io.Reader) (int64, error) {
+	if src, ok := reader.(*sourceFile); ok {
+		if !o.denseCopy || !src.sparse {
+			if err := o.fs.IoctlFileClone(dst.Fd(), src.Fd()); err == nil {
+				o.result.Copy = CopyClone
+				o.progress.add(src.size)
+				return src.size, nil
+			} else if !isUnsupportedCopy(err) {
+				return 0, err
+			}
+		}
+		if src.sparse && !o.denseCopy {
+			if _, err := src.Seek(0, seekData); !isUnsupportedCopy(err) {
+				o.result.Copy = CopySparse
+				return copySparse(o, dst, src)
+			}
+		}
+		n, err := copyInKernel(o, src, func() (int, error) {
+			return o.fs.CopyFileRange(src.Fd(), nil, dst.Fd(), nil, copyChunkSize, 0)
+		})
+		if n > 0 || !isUnsupportedCopy(err) {
+			o.result.Copy = CopyFileRange
+			return n, err
+		}
+		n, err = copyInKernel(o, src, func() (int, error) {
+			return o.fs.Sendfile(dst.Fd(), src.Fd(), nil, copyChunkSize)
+		})
+		if n > 0 || !isUnsupportedCopy(err) {
+			o.result.Copy = CopySendfile
+			return n, err
+		}
+	}
+	o.result.Copy = CopyBuffered
+	return io.Copy(dst, trackReader(reader, o.ctx, o.progress))
+}
+
+// copySparse copies the data segments of src to the same offsets in dst, which must be empty,
+// leaving the holes between them unallocated. It returns the size of src on success.
+// Holes count towards the progress like data, so that it reaches the size of src.
+func copySparse(o *options, dst fsi.File, src *sourceFile) (int64, error) {
+	var offset int64
+	for offset < src.size {
+		if err := contextErr(o.ctx); err != nil {
+			return offset, err
+		}
+		dataStart, err := src.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// Only a hole remains.
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		o.progress.add(dataStart - offset)
+		dataEnd, err := src.Seek(dataStart, seekHole)
+		if err != nil {
+			return 0, err
+		}
+		if err = copyRange(o, dst, src, dataStart, dataEnd-dataStart); err != nil {
+			return dataStart, err
+		}
+		offset = dataEnd
+	}
+	// Extending dst to the size of src creates the trailing hole, if any.
+	if err := dst.Truncate(src.size); err != nil {
+		return offset, err
+	}
+	o.progress.add(src.size - offset)
+	return src.size, nil
+}
+
+// copyRange copies length bytes at offset in src to the same offset in dst, using copy_file_range(2) if possible.
+func copyRange(o *options, dst fsi.File, src *sourceFile, offset, length int64) error {
+	srcOffset, dstOffset := offset, offset
+	for length > 0 {
+		if err := contextErr(o.ctx); err != nil {
+			return err
+		}
+		n, err := o.fs.CopyFileRange(src.Fd(), &srcOffset, dst.Fd(), &dstOffset, int(min64(length, copyChunkSize)), 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if n == 0 && (err == nil || isUnsupportedCopy(err)) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		length -= int64(n)
+		o.progress.add(int64(n))
+	}
+	buf := make([]byte, min64(length, 32*1024))
+	for length > 0 {
+		if err := contextErr(o.ctx); err != nil {
+			return err
+		}
+		n, err := src.ReadAt(buf[:min64(length, int64(len(buf)))], srcOffset)
+		if n > 0 {
+			if _, err := dst.WriteAt(buf[:n], dstOffset); err != nil {
+				return err
+			}
+			srcOffset += int64(n)
+			dstOffset += int64(n)
+			length -= int64(n)
+			o.progress.add(int64(n))
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// copyInKernel calls transfer until it reports the end of src and returns the total amount of bytes transferred.
+// Some file systems report the end of the file right away instead of failing if they do not support a method;
+// this case is reported as ENOTSUP if src is not empty.
+func copyInKernel(o *options, src *sourceFile, transfer func() (int, error)) (int64, error) {
+	var written int64
+	for {
+		if err := contextErr(o.ctx); err != nil {
+			return written, err
+		}
+		n, err := transfer()
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			if written == 0 && src.size > 0 {
+				return 0, syscall.ENOTSUP
+			}
+			return written, nil
+		}
+		written += int64(n)
+		o.progress.add(int64(n))
+	}
+}
+
+// isUnsupportedCopy reports whether err means that a method of the copy engine is not supported for the files involved.
+func isUnsupportedCopy(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOSYS, syscall.ENOTSUP, syscall.EOPNOTSUPP, syscall.ENOTTY, syscall.EXDEV, syscall.EINVAL, syscall.EPERM, syscall.EBADF} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackingReader fails with the error of ctx once ctx is done and counts all bytes read from Reader with progress.
+type trackingReader struct {
+	io.Reader
+	ctx      context.Context
+	progress *progressTracker
+}
+
+// trackReader returns a reader which reads from reader, but fails once ctx is done and counts the bytes read
+// with progress. ctx and progress may be nil, in which case reader itself may be returned.
+func trackReader(reader io.Reader, ctx context.Context, progress *progressTracker) io.Reader {
+	if ctx == nil && progress == nil {
+		return reader
+	}
+	return &trackingReader{Reader: reader, ctx: ctx, progress: progress}
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	if err := contextErr(r.ctx); err != nil {
+		return 0, err
+	}
+	n, err := r.Reader.Read(p)
+	r.progress.add(int64(n))
+	return n, err
+}
+
+// contextErr returns ctx.Err(), or nil if ctx is nil.
+func contextErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// totalSize returns the amount of data to be read from reader, or -1 if unknown.
+func totalSize(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case *sourceFile:
+		return r.size
+	case interface{ Len() int }:
+		return int64(r.Len())
+	}
+	return -1
+}
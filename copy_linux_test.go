@@ -0,0 +1,291 @@
+package fio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyFileTransfersLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.bin")
+	destinationPath := filepath.Join(dir, "destination.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), (3*copyChunkSize)/16+7)
+	if err := os.WriteFile(sourcePath, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var result Result
+	n := CopyFile(sourcePath, destinationPath, WithResult(&result))
+
+	if n != int64(len(data)) || result.Bytes != n || result.Copy == CopyNone {
+		t.Fatalf("expected %d bytes copied, got %d and %+v", len(data), n, result)
+	}
+	if copied, err := os.ReadFile(destinationPath); err != nil || !bytes.Equal(copied, data) {
+		t.Fatalf("expected destination to equal source using %v (%v)", result.Copy, err)
+	}
+}
+
+func TestWriteFileWithReaderStartsAtFilePosition(t *testing.T) {
+	filePath := createTestFile(t)
+	reader, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err = reader.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	var result Result
+	destinationPath := filepath.Join(filepath.Dir(filePath), "destination.txt")
+	WriteFileWithReader(destinationPath, reader, WithResult(&result))
+
+	expectFileContent(t, destinationPath, testData[6:])
+	if result.Copy != CopyBuffered {
+		t.Fatalf("expected %v, got %v", CopyBuffered, result.Copy)
+	}
+}
+
+func TestCopyFileKeepsHoles(t *testing.T) {
+	sourcePath := createSparseTestFile(t)
+	destinationPath := filepath.Join(filepath.Dir(sourcePath), "destination.img")
+
+	var result Result
+	CopyFile(sourcePath, destinationPath, WithResult(&result))
+
+	expectSameContent(t, sourcePath, destinationPath)
+	if blocks := allocatedBlocks(t, destinationPath); blocks > allocatedBlocks(t, sourcePath) {
+		t.Fatalf("expected at most %d blocks allocated using %v, got %d", allocatedBlocks(t, sourcePath), result.Copy, blocks)
+	}
+}
+
+func TestCopyFileWithDenseCopy(t *testing.T) {
+	sourcePath := createSparseTestFile(t)
+	destinationPath := filepath.Join(filepath.Dir(sourcePath), "destination.img")
+
+	var result Result
+	CopyFile(sourcePath, destinationPath, WithDenseCopy(), WithResult(&result))
+
+	expectSameContent(t, sourcePath, destinationPath)
+	if result.Copy == CopyClone || result.Copy == CopySparse {
+		t.Fatalf("expected dense copy, got %v", result.Copy)
+	}
+	if blocks := allocatedBlocks(t, destinationPath); blocks*512 < sparseTestFileSize {
+		t.Fatalf("expected at least %d blocks allocated using %v, got %d", sparseTestFileSize/512, result.Copy, blocks)
+	}
+}
+
+func TestCopyFileProgressIncludesHoles(t *testing.T) {
+	sourcePath := createSparseTestFile(t)
+	destinationPath := filepath.Join(filepath.Dir(sourcePath), "destination.img")
+
+	var result Result
+	var last Progress
+	CopyFile(sourcePath, destinationPath, WithResult(&result), WithProgress(time.Hour, func(p Progress) { last = p }))
+
+	if !last.Done || last.Bytes != sparseTestFileSize || last.Remaining() != 0 {
+		t.Fatalf("expected final report of %d bytes using %v, got %+v", sparseTestFileSize, result.Copy, last)
+	}
+}
+
+const sparseTestFileSize = 16 << 20
+
+// createSparseTestFile creates a file with two data segments surrounded by holes.
+func createSparseTestFile(t *testing.T) string {
+	filePath := filepath.Join(t.TempDir(), "source.img")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, offset := range []int64{1 << 20, 8 << 20} {
+		if _, err = file.WriteAt(bytes.Repeat([]byte(testData), 1000), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = file.Truncate(sparseTestFileSize); err != nil {
+		t.Fatal(err)
+	}
+	if blocks := allocatedBlocks(t, filePath); blocks*512 >= sparseTestFileSize {
+		t.Skipf("file system does not support sparse files")
+	}
+	return filePath
+}
+
+func allocatedBlocks(t *testing.T, filePath string) int64 {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(info.Sys().(*syscall.Stat_t).Blocks)
+}
+
+func expectSameContent(t *testing.T, filePath, otherFilePath string) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherData, err := os.ReadFile(otherFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, otherData) {
+		t.Fatalf("expected '%s' to have the same content as '%s'", otherFilePath, filePath)
+	}
+}
+
+func TestCopyFileWithPreserve(t *testing.T) {
+	for name, opt := range map[string]Option{"direct": WithLocker(DefaultLocker), "atomic": WithAtomicWrite(), "staged": WithPreserveOnFailure()} {
+		t.Run(name, func(t *testing.T) {
+			sourcePath := createTestFile(t)
+			destinationPath := filepath.Join(filepath.Dir(sourcePath), "destination.txt")
+			WriteFilePerm(destinationPath, []byte("Hello Gopher"), 0600)
+			modTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+			if err := os.Chmod(sourcePath, 0741|os.ModeSetgid); err != nil {
+				t.Fatal(err)
+			}
+			hasXattr := syscall.Setxattr(sourcePath, "user.fio.test", []byte("preserved"), 0) == nil
+			if err := os.Chtimes(sourcePath, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			CopyFile(sourcePath, destinationPath, opt, WithPreserve(PreserveAll|PreserveStrict))
+
+			expectFileContent(t, destinationPath, testData)
+			info, err := os.Stat(destinationPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != 0741|os.ModeSetgid || !info.ModTime().Equal(modTime) {
+				t.Fatalf("expected mode %v and modification time %v, got %v and %v", 0741|os.ModeSetgid, modTime, info.Mode(), info.ModTime())
+			}
+			if hasXattr {
+				value := make([]byte, 64)
+				n, err := syscall.Getxattr(destinationPath, "user.fio.test", value)
+				if err != nil || string(value[:n]) != "preserved" {
+					t.Fatalf("expected extended attribute to be preserved, got %q (%v)", value[:n], err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyAndMoveFileWithChecksum(t *testing.T) {
+	sourcePath := createTestFile(t)
+	copyPath := filepath.Join(filepath.Dir(sourcePath), "copy.txt")
+	movePath := filepath.Join(filepath.Dir(sourcePath), "moved.txt")
+	expectedDigest := sha256.Sum256([]byte(testData))
+
+	var result Result
+	CopyFile(sourcePath, copyPath, WithChecksum(sha256.New), WithResult(&result))
+	if !bytes.Equal(result.Digest, expectedDigest[:]) || result.Copy != CopyBuffered {
+		t.Fatalf("expected digest %x using %v, got %x using %v", expectedDigest, CopyBuffered, result.Digest, result.Copy)
+	}
+	expectFileContent(t, copyPath, testData)
+
+	MoveFile(copyPath, movePath, WithChecksum(sha256.New), WithResult(&result))
+	if !bytes.Equal(result.Digest, expectedDigest[:]) {
+		t.Fatalf("expected digest %x, got %x", expectedDigest, result.Digest)
+	}
+}
+
+func TestCopyFileWithProgress(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.bin")
+	destinationPath := filepath.Join(dir, "destination.bin")
+	data := bytes.Repeat([]byte(testData), copyChunkSize/len(testData)*3)
+	if err := os.WriteFile(sourcePath, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []Progress
+	CopyFile(sourcePath, destinationPath, WithProgress(0, func(p Progress) { reports = append(reports, p) }))
+
+	if len(reports) < 2 {
+		t.Fatalf("expected several reports, got %+v", reports)
+	}
+	for i, p := range reports {
+		if p.Total != int64(len(data)) || (i > 0 && p.Bytes < reports[i-1].Bytes) {
+			t.Fatalf("expected increasing progress of %d bytes, got %+v", len(data), reports)
+		}
+	}
+	if last := reports[len(reports)-1]; !last.Done || last.Bytes != int64(len(data)) || last.Remaining() != 0 {
+		t.Fatalf("expected final report of %d bytes, got %+v", len(data), last)
+	}
+}
+
+func TestWriteFileWithReaderProgressOfUnknownTotal(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+
+	var last Progress
+	WriteFileWithReader(filePath, io.MultiReader(strings.NewReader(testData)), WithProgress(time.Hour, func(p Progress) { last = p }))
+
+	if !last.Done || last.Bytes != int64(len(testData)) || last.Total != -1 || last.Remaining() != -1 {
+		t.Fatalf("expected final report of %d bytes of unknown total, got %+v", len(testData), last)
+	}
+}
+
+func TestCopyFileContextCancellation(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.bin")
+	destinationPath := filepath.Join(dir, "destination.bin")
+	if err := os.WriteFile(sourcePath, make([]byte, 3*copyChunkSize), 0640); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ofd := WithLocker(OFDLocker{})
+
+	_, err := TryCopyFileContext(ctx, sourcePath, destinationPath, ofd, WithProgress(0, func(Progress) { cancel() }))
+
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrLocked) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err = os.Stat(destinationPath); !os.IsNotExist(err) {
+		t.Fatalf("expected destination to be removed, got %v", err)
+	}
+	if IsLocked(sourcePath, ofd) {
+		t.Fatalf("expected source to be unlocked")
+	}
+}
+
+func TestWriteFileWithReaderContextCancellation(t *testing.T) {
+	filePath := createTestFile(t)
+	newFilePath := filepath.Join(filepath.Dir(filePath), "new.txt")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := io.MultiReader(strings.NewReader("partial"), &cancelingReader{cancel: cancel}, strings.NewReader("never read"))
+
+	if _, err := TryWriteFileWithReaderContext(ctx, newFilePath, reader); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(newFilePath); !os.IsNotExist(err) {
+		t.Fatalf("expected created file to be removed, got %v", err)
+	}
+
+	reader = io.MultiReader(strings.NewReader("partial"), &cancelingReader{cancel: cancel})
+	if _, err := TryWriteFileWithReaderContext(ctx, filePath, reader, WithPreserveOnFailure()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	expectFileContent(t, filePath, testData)
+	expectNoTempFiles(t, filepath.Dir(filePath))
+}
+
+// cancelingReader calls cancel when read from and then reports the end of its data.
+type cancelingReader struct {
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return 0, io.EOF
+}
@@ -0,0 +1,38 @@
+package fio
+
+// Durability determines how far fio flushes written data to stable storage before reporting success.
+type Durability int
+
+const (
+	// DurabilityNone leaves flushing written data to the operating system.
+	// The data may be lost if the system crashes or loses power shortly after writing.
+	DurabilityNone Durability = iota
+	// DurabilityData flushes the content of written files with fdatasync(2), which skips
+	// metadata such as the modification time unless it is needed to read the data back.
+	DurabilityData
+	// DurabilityFile flushes the content and metadata of written files with fsync(2).
+	DurabilityFile
+	// DurabilityDir is like DurabilityFile, but also flushes the parent directory of written files,
+	// so that newly created, renamed or removed directory entries survive a crash as well.
+	DurabilityDir
+)
+
+// DefaultDurability is the Durability used by all calls which do not specify one with WithDurability().
+//
+// Independently of this setting, atomic writes always flush the temporary file and the parent directory,
+// and MoveFile always uses at least DurabilityDir for the destination before removing the source.
+var DefaultDurability = DurabilityNone
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityNone:
+		return "none"
+	case DurabilityData:
+		return "fdatasync"
+	case DurabilityFile:
+		return "fsync"
+	case DurabilityDir:
+		return "fsync+dir"
+	}
+	return "unknown durability"
+}
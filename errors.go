@@ -0,0 +1,119 @@
+package fio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// ErrLocked matches errors (using errors.Is) which were caused by another process
+// holding a conflicting advisory lock on a file.
+var ErrLocked = errors.New("file is locked")
+
+// ErrNotExist matches errors (using errors.Is) which were caused by a file not existing.
+// It is the same value as fs.ErrNotExist.
+var ErrNotExist = fs.ErrNotExist
+
+// ErrNoXattr matches errors (using errors.Is) which were caused by an extended attribute not existing.
+// It is the same value as syscall.ENODATA on Linux and syscall.ENOATTR on other systems.
+var ErrNoXattr error = errNoXattr
+
+// ErrChecksumMismatch matches errors (using errors.Is) which were caused by the data read back
+// from a written file not matching the data written. See WithChecksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// LockError records a failure to claim an advisory lock.
+type LockError struct {
+	Path string   // The path of the file which was to be locked.
+	Type LockType // The type of the lock which was to be claimed.
+	PID  int      // The ID of the process holding a conflicting lock or 0 if unknown.
+	Err  error    // The underlying error; usually a syscall.Errno.
+}
+
+func (e *LockError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("acquire %v: %v (held by PID %d)", e.Type, e.Err, e.PID)
+	}
+	return fmt.Sprintf("acquire %v: %v", e.Type, e.Err)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrLocked and the lock could not be claimed
+// because another process holds a conflicting lock. This includes giving up
+// waiting for the lock because a context was done.
+func (e *LockError) Is(target error) bool {
+	return target == ErrLocked && isLockConflict(e.Err)
+}
+
+func isLockConflict(err error) bool {
+	return errors.Is(err, ErrLocked) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// conflictError marks an error of fcntl(2) which reports a conflicting lock, so that it matches ErrLocked.
+// fcntl(2) reports conflicts as EACCES on some systems, which in general means a lack of permission.
+type conflictError struct {
+	err error
+}
+
+func (e *conflictError) Error() string {
+	return e.err.Error()
+}
+
+func (e *conflictError) Unwrap() error {
+	return e.err
+}
+
+func (e *conflictError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// fcntlConflict wraps err in a conflictError if it was returned by fcntl(2) because of a conflicting lock.
+func fcntlConflict(err error) error {
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return &conflictError{err: err}
+	}
+	return err
+}
+
+// OpError records a failed fio operation, such as "copy" or "write", and the file paths involved.
+// All functions of package fio which return or panic with an error wrap it in an OpError.
+type OpError struct {
+	Op   string // The operation, e.g. "copy".
+	From string // The path of the file operated on; or the source path for operations involving two files.
+	To   string // The destination path for operations involving two files; empty otherwise.
+	Err  error  // The underlying error.
+}
+
+func (e *OpError) Error() string {
+	if e.To == "" {
+		return fmt.Sprintf("%s '%s': %v", e.Op, e.From, e.Err)
+	}
+	return fmt.Sprintf("%s '%s' to '%s': %v", e.Op, e.From, e.To, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// newOpError wraps err in an OpError. If err is an *fs.PathError or *os.LinkError for the
+// same operation, it is unwrapped first so that its paths do not appear twice in the message.
+func newOpError(op, from, to string, err error) *OpError {
+	switch e := err.(type) {
+	case *fs.PathError:
+		if e.Op == op {
+			err = e.Err
+		}
+	case *os.LinkError:
+		if e.Op == op {
+			err = e.Err
+		}
+	}
+	return &OpError{Op: op, From: from, To: to, Err: err}
+}
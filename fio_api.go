@@ -1,28 +1,41 @@
 package fio
 
 import (
-	"bytes"
+	"context"
 	"io"
 	"io/fs"
 	"os"
+	"time"
 
 	"github.com/setlog/panik"
 )
 
 // OpenFile opens the file at filePath in the same manner as os.OpenFile, but also
 // claims an advisory lock matching your access flags (r/w/rw) which will be released
-// when closing the file.
+// when closing the returned file, or earlier by calling its Unlock() method.
+// If flag contains os.O_TRUNC, the file is only truncated once the lock has been claimed.
 //
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
-// Unlike the other functions in this package, this function will never log, because
-// it will not know when you close the file descriptor.
+// Unlike the other functions in this package, this function does not log itself.
+// Instead, the returned file logs when it is closed.
 //
 // Errors result in panics created with panik.
-func OpenFile(filePath string, flag int, perm fs.FileMode) *os.File {
-	file, err := fsApi.OpenFile(filePath, flag, perm)
+func OpenFile(filePath string, flag int, perm fs.FileMode, opts ...Option) *LockedFile {
+	file, err := TryOpenFile(filePath, flag, perm, opts...)
 	panik.OnError(err)
-	return file.(*os.File)
+	return file
+}
+
+// OpenFileContext is like OpenFile, but if another process holds a conflicting advisory lock,
+// it waits for the lock to be released until ctx is done. Use context.WithTimeout() to bound the wait.
+// If ctx is done first, the file is closed again and the resulting error matches both ErrLocked and ctx.Err().
+//
+// Errors result in panics created with panik.
+func OpenFileContext(ctx context.Context, filePath string, flag int, perm fs.FileMode, opts ...Option) *LockedFile {
+	file, err := TryOpenFileContext(ctx, filePath, flag, perm, opts...)
+	panik.OnError(err)
+	return file
 }
 
 // ReadFile opens the file at filePath, claims an advisory read lock, reads all
@@ -31,20 +44,37 @@ func OpenFile(filePath string, flag int, perm fs.FileMode) *os.File {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func ReadFile(filePath string) []byte {
-	data := readFile(filePath)
-	if log := logger(); log != nil {
-		log.Printf("Read '%s'.", filePath)
-	}
+func ReadFile(filePath string, opts ...Option) []byte {
+	data, err := TryReadFile(filePath, opts...)
+	panik.OnError(err)
+	return data
+}
+
+// ReadFileContext is like ReadFile, but waits for conflicting advisory locks
+// to be released until ctx is done. See OpenFileContext. If ctx is done while
+// transferring data, the operation is aborted and the error matches ctx.Err().
+//
+// Errors result in panics created with panik.
+func ReadFileContext(ctx context.Context, filePath string, opts ...Option) []byte {
+	data, err := TryReadFileContext(ctx, filePath, opts...)
+	panik.OnError(err)
 	return data
 }
 
-// MoveFile creates a file at toFilePath, truncating it if it already exists,
-// writes to it all data read from the file at fromFilePath, removes the file
-// at fromFilePath, logs on success and returns the amount of bytes moved.
+// MoveFile moves the file at fromFilePath to toFilePath, replacing any file
+// at toFilePath, logs on success and returns the amount of bytes moved.
+//
+// If both paths are on the same mount, the file is renamed, which is instant and atomic.
+// Otherwise, MoveFile creates a file at toFilePath, truncating it if it already exists,
+// writes to it all data read from the file at fromFilePath and removes the file at fromFilePath.
+// This allows for files to be moved between mounts, which is not possible when using os.Rename().
+// The strategy used is logged and can be retrieved with WithResult().
+//
+// When copying, the file at fromFilePath is only removed once the file at toFilePath and its directory
+// entry have been flushed to stable storage, regardless of the configured Durability.
 //
-// Explicitly creating the target file effectively allows for it to be moved between mounts,
-// which is not possible when using os.Rename().
+// If toFilePath is a symbolic link, the file it points to is replaced, not the link itself, regardless of the
+// strategy. Files at toFilePath which are not regular files, such as devices, are always written to by copying.
 //
 // For the operation, an advisory read lock is claimed for the file at fromFilePath
 // and an advisory write lock is claimed for the file at toFilePath.
@@ -52,40 +82,62 @@ func ReadFile(filePath string) []byte {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func MoveFile(fromFilePath, toFilePath string) int64 {
-	n, err := moveFile(fromFilePath, toFilePath)
-	if err != nil {
-		panik.Panicf("move '%s' to '%s': %w", fromFilePath, toFilePath, err)
-	}
-	if log := logger(); log != nil {
-		log.Printf("Moved '%s' to '%s'.", fromFilePath, toFilePath)
-	}
+func MoveFile(fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := TryMoveFile(fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// MoveFileContext is like MoveFile, but waits for conflicting advisory locks
+// to be released until ctx is done. See OpenFileContext. If ctx is done while
+// copying data, the operation is aborted, a destination file created by the call
+// is removed, the source is kept and the error matches ctx.Err().
+//
+// Errors result in panics created with panik.
+func MoveFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := TryMoveFileContext(ctx, fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
 	return n
 }
 
-// RenameFile is a shorthand for panik.OnError(os.Rename(fromFilePath, toFilePath)).
+// RenameFile is a shorthand for panik.OnError(os.Rename(fromFilePath, toFilePath)),
+// with the error wrapped in an *OpError. Of opts, only WithFileSystem() has an effect.
 //
 // If fromFilePath and toFilePath are on different mounts, consider using MoveFile() instead.
-func RenameFile(fromFilePath, toFilePath string) {
-	panik.OnError(os.Rename(fromFilePath, toFilePath))
+func RenameFile(fromFilePath, toFilePath string, opts ...Option) {
+	panik.OnError(TryRenameFile(fromFilePath, toFilePath, opts...))
 }
 
 // CopyFile creates a file at toFilePath, truncating it if it already exists,
 // writes to it all data read from the file at fromFilePath,  logs on success
 // and returns the amount of bytes copied.
 //
+// The data is transferred by the kernel where possible: the destination is cloned from the source
+// on copy-on-write file systems, or filled with copy_file_range(2) or sendfile(2), before falling
+// back to buffered copying. The method used is logged and can be retrieved with WithResult().
+// Holes in sparse files are recreated in the destination unless WithDenseCopy() is used.
+//
 // For the operation, an advisory read lock is claimed for the file at fromFilePath
 // and an advisory write lock is claimed for the file at toFilePath.
 //
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func CopyFile(fromFilePath, toFilePath string) int64 {
-	n, err := copyFile(fromFilePath, toFilePath)
+func CopyFile(fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := TryCopyFile(fromFilePath, toFilePath, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// CopyFileContext is like CopyFile, but waits for conflicting advisory locks
+// to be released until ctx is done. See OpenFileContext. If ctx is done while
+// copying data, the operation is aborted, a destination file created by the call
+// is removed and the error matches ctx.Err().
+//
+// Errors result in panics created with panik.
+func CopyFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) int64 {
+	n, err := TryCopyFileContext(ctx, fromFilePath, toFilePath, opts...)
 	panik.OnError(err)
-	if log := logger(); log != nil {
-		log.Printf("Copied '%s' to '%s'.", fromFilePath, toFilePath)
-	}
 	return n
 }
 
@@ -97,12 +149,17 @@ func CopyFile(fromFilePath, toFilePath string) int64 {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func WriteFile(filePath string, data []byte) {
-	_, err := writeFile(filePath, bytes.NewReader(data), 0660)
-	panik.OnError(err)
-	if log := logger(); log != nil {
-		log.Printf("Wrote '%s'.", filePath)
-	}
+func WriteFile(filePath string, data []byte, opts ...Option) {
+	panik.OnError(TryWriteFile(filePath, data, opts...))
+}
+
+// WriteFileContext is like WriteFile, but waits for conflicting advisory locks
+// to be released until ctx is done. See OpenFileContext. If ctx is done while
+// transferring data, the operation is aborted and the error matches ctx.Err().
+//
+// Errors result in panics created with panik.
+func WriteFileContext(ctx context.Context, filePath string, data []byte, opts ...Option) {
+	panik.OnError(TryWriteFileContext(ctx, filePath, data, opts...))
 }
 
 // WriteFilePerm creates a file with permissions perm at filePath, truncating it
@@ -113,12 +170,8 @@ func WriteFile(filePath string, data []byte) {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func WriteFilePerm(filePath string, data []byte, perm fs.FileMode) {
-	_, err := writeFile(filePath, bytes.NewReader(data), perm)
-	panik.OnError(err)
-	if log := logger(); log != nil {
-		log.Printf("Wrote '%s'.", filePath)
-	}
+func WriteFilePerm(filePath string, data []byte, perm fs.FileMode, opts ...Option) {
+	panik.OnError(TryWriteFilePerm(filePath, data, perm, opts...))
 }
 
 // WriteFileWithReader creates a file at filePath, truncating it if it already exists,
@@ -129,12 +182,21 @@ func WriteFilePerm(filePath string, data []byte, perm fs.FileMode) {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func WriteFileWithReader(filePath string, reader io.Reader) int64 {
-	n, err := writeFile(filePath, reader, 0660)
+func WriteFileWithReader(filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := TryWriteFileWithReader(filePath, reader, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// WriteFileWithReaderContext is like WriteFileWithReader, but waits for conflicting advisory locks
+// to be released until ctx is done. See OpenFileContext. If ctx is done while transferring data,
+// reading from reader stops, a destination file created by the call is removed as if the write
+// had failed, and the error matches ctx.Err(). Note that ctx cannot interrupt a pending Read() call.
+//
+// Errors result in panics created with panik.
+func WriteFileWithReaderContext(ctx context.Context, filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := TryWriteFileWithReaderContext(ctx, filePath, reader, opts...)
 	panik.OnError(err)
-	if log := logger(); log != nil {
-		log.Printf("Wrote '%s'.", filePath)
-	}
 	return n
 }
 
@@ -147,29 +209,164 @@ func WriteFileWithReader(filePath string, reader io.Reader) int64 {
 // Note that opening a file and getting an advisory lock are not (and cannot be) an atomic operation.
 //
 // Errors result in panics created with panik.
-func WriteFileWithReaderPerm(filePath string, reader io.Reader, perm os.FileMode) int64 {
-	n, err := writeFile(filePath, reader, perm)
+func WriteFileWithReaderPerm(filePath string, reader io.Reader, perm os.FileMode, opts ...Option) int64 {
+	n, err := TryWriteFileWithReaderPerm(filePath, reader, perm, opts...)
 	panik.OnError(err)
-	if log := logger(); log != nil {
-		log.Printf("Wrote '%s'.", filePath)
-	}
 	return n
 }
 
+// WriteFileAtomic is like WriteFile, but replaces the file atomically. See WithAtomicWrite.
+//
+// Errors result in panics created with panik.
+func WriteFileAtomic(filePath string, data []byte, opts ...Option) {
+	panik.OnError(TryWriteFileAtomic(filePath, data, opts...))
+}
+
+// WriteFileWithReaderAtomic is like WriteFileWithReader, but replaces the file atomically. See WithAtomicWrite.
+//
+// Errors result in panics created with panik.
+func WriteFileWithReaderAtomic(filePath string, reader io.Reader, opts ...Option) int64 {
+	n, err := TryWriteFileWithReaderAtomic(filePath, reader, opts...)
+	panik.OnError(err)
+	return n
+}
+
+// ReadRange opens the file at filePath, claims an advisory read lock on only the n bytes
+// starting at offset, reads them, closes the file, logs on success and returns the read bytes.
+// Fewer than n bytes are returned if the file ends before.
+//
+// The Locker in use must implement RangeLocker.
+//
+// WARNING: With FcntlLocker, closing the file releases all fcntl(2) locks the calling process holds
+// on it, including those of files opened with OpenFile. While holding such a LockedFile, use its
+// LockRange and ReadAt methods instead.
+//
+// Errors result in panics created with panik.
+func ReadRange(filePath string, offset int64, n int, opts ...Option) []byte {
+	data, err := TryReadRange(filePath, offset, n, opts...)
+	panik.OnError(err)
+	return data
+}
+
+// WriteRange opens the existing file at filePath, claims an advisory write lock on only the
+// len(data) bytes starting at offset, writes data there, closes the file and logs on success.
+//
+// The Locker in use must implement RangeLocker.
+//
+// WARNING: With FcntlLocker, closing the file releases all fcntl(2) locks the calling process holds
+// on it. See ReadRange.
+//
+// Errors result in panics created with panik.
+func WriteRange(filePath string, offset int64, data []byte, opts ...Option) {
+	panik.OnError(TryWriteRange(filePath, offset, data, opts...))
+}
+
+// IsLocked reports whether another process holds an advisory lock on the file at filePath.
+// See LockHolder, including its warning about fcntl(2) locks.
+//
+// Errors result in panics created with panik.
+func IsLocked(filePath string, opts ...Option) bool {
+	locked, err := TryIsLocked(filePath, opts...)
+	panik.OnError(err)
+	return locked
+}
+
+// LockHolder returns information about an advisory lock held on the file at filePath,
+// including the ID of the process holding it, or nil if the file is not locked.
+// If several locks are held, one of them is returned.
+//
+// With FcntlLocker, locks held by the calling process itself are not reported.
+// With OFDLocker, the PID of OFD locks is reported as -1.
+//
+// WARNING: The lock is queried through a descriptor opened and closed for this purpose. With FcntlLocker,
+// closing it releases all fcntl(2) locks the calling process holds on the file, including those of files
+// opened with OpenFile. While holding such a LockedFile, call its LockHolder method instead.
+//
+// Errors result in panics created with panik.
+func LockHolder(filePath string, opts ...Option) *LockInfo {
+	holder, err := TryLockHolder(filePath, opts...)
+	panik.OnError(err)
+	return holder
+}
+
+// WaitUntilUnlocked waits until no other process holds an advisory lock on the file at filePath,
+// e.g. because an FTP server finished receiving it, or until ctx is done. See LockHolder.
+//
+// WARNING: Every check opens and closes the file, which with FcntlLocker releases all fcntl(2) locks
+// the calling process holds on it. Do not call this while holding a lock on the file yourself.
+//
+// Errors result in panics created with panik.
+func WaitUntilUnlocked(ctx context.Context, filePath string, opts ...Option) {
+	panik.OnError(TryWaitUntilUnlocked(ctx, filePath, opts...))
+}
+
+// WaitUntilStable waits until the file at filePath is not locked by another process and
+// neither its size nor its modification time have changed for quietPeriod, or until ctx is done.
+// Use this to detect completed uploads of FTP servers which do not claim advisory locks reliably,
+// or which briefly release them while receiving a file.
+//
+// WARNING: Like WaitUntilUnlocked, this releases the fcntl(2) locks of the calling process on the file
+// with every check when using FcntlLocker.
+//
+// Errors result in panics created with panik.
+func WaitUntilStable(ctx context.Context, filePath string, quietPeriod time.Duration, opts ...Option) {
+	panik.OnError(TryWaitUntilStable(ctx, filePath, quietPeriod, opts...))
+}
+
+// ListXattr returns the names of the extended attributes of the file at filePath,
+// such as "user.state", while holding an advisory read lock on it.
+//
+// WARNING: With FcntlLocker, closing the file afterwards releases all fcntl(2) locks the calling process
+// holds on it, including those of files opened with OpenFile. The same applies to GetXattr, SetXattr and RemoveXattr.
+//
+// Errors result in panics created with panik.
+func ListXattr(filePath string, opts ...Option) []string {
+	names, err := TryListXattr(filePath, opts...)
+	panik.OnError(err)
+	return names
+}
+
+// GetXattr returns the value of the extended attribute name of the file at filePath
+// while holding an advisory read lock on it. If the attribute does not exist, the error
+// matches ErrNoXattr.
+//
+// WARNING: With FcntlLocker, this releases the fcntl(2) locks of the calling process on the file. See ListXattr.
+//
+// Errors result in panics created with panik.
+func GetXattr(filePath, name string, opts ...Option) []byte {
+	value, err := TryGetXattr(filePath, name, opts...)
+	panik.OnError(err)
+	return value
+}
+
+// SetXattr creates or replaces the extended attribute name of the file at filePath
+// while holding an advisory write lock on it, and logs on success. To claim the lock,
+// the file is opened for writing, which requires write permission.
+//
+// WARNING: With FcntlLocker, this releases the fcntl(2) locks of the calling process on the file. See ListXattr.
+//
+// Errors result in panics created with panik.
+func SetXattr(filePath, name string, value []byte, opts ...Option) {
+	panik.OnError(TrySetXattr(filePath, name, value, opts...))
+}
+
+// RemoveXattr removes the extended attribute name of the file at filePath while holding
+// an advisory write lock on it, and logs on success. See SetXattr.
+// If the attribute does not exist, the error matches ErrNoXattr.
+//
+// WARNING: With FcntlLocker, this releases the fcntl(2) locks of the calling process on the file. See ListXattr.
+//
+// Errors result in panics created with panik.
+func RemoveXattr(filePath, name string, opts ...Option) {
+	panik.OnError(TryRemoveXattr(filePath, name, opts...))
+}
+
 // RemoveFile removes the file at filePath if it exists, logs this
 // and returns true on success. Returns false if the file did not exist.
 //
 // Errors result in panics created with panik.
-func RemoveFile(filePath string) bool {
-	err := os.Remove(filePath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			panik.OnError(err)
-		}
-		return false
-	}
-	if log := logger(); log != nil {
-		log.Printf("Removed '%s'.", filePath)
-	}
-	return true
+func RemoveFile(filePath string, opts ...Option) bool {
+	removed, err := TryRemoveFile(filePath, opts...)
+	panik.OnError(err)
+	return removed
 }
@@ -21,14 +21,58 @@ func (fs *fileSystemImpl) FcntlFlock(fd uintptr, cmd int, lk *syscall.Flock_t) e
 	return syscall.FcntlFlock(fd, cmd, lk)
 }
 
+func (fs *fileSystemImpl) Flock(fd uintptr, how int) error {
+	return syscall.Flock(int(fd), how)
+}
+
 func (fs *fileSystemImpl) Remove(name string) error {
 	return os.Remove(name)
 }
 
+func (fs *fileSystemImpl) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
 func (fs *fileSystemImpl) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
 
+func (fs *fileSystemImpl) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (fs *fileSystemImpl) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (fs *fileSystemImpl) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (fs *fileSystemImpl) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (fs *fileSystemImpl) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (fs *fileSystemImpl) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (fs *fileSystemImpl) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (fs *fileSystemImpl) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (fs *fileSystemImpl) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
 type fileInfoImpl struct {
 	name string
 	size int64
@@ -0,0 +1,63 @@
+package fio
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// fiClone is the FICLONE ioctl request, which makes a file share all data of another file on copy-on-write file systems.
+const fiClone = 0x40049409
+
+// copyFileRangeTrap is the number of the copy_file_range(2) system call, which the syscall package does not provide,
+// or 0 on architectures not listed here.
+var copyFileRangeTrap = map[string]uintptr{
+	"386":     377,
+	"amd64":   326,
+	"arm":     391,
+	"arm64":   285,
+	"loong64": 285,
+	"riscv64": 285,
+}[runtime.GOARCH]
+
+func (fs *fileSystemImpl) Fdatasync(fd uintptr) error {
+	return syscall.Fdatasync(int(fd))
+}
+
+func (fs *fileSystemImpl) IoctlFileClone(dstFd, srcFd uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dstFd, fiClone, srcFd); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (fs *fileSystemImpl) CopyFileRange(srcFd uintptr, srcOff *int64, dstFd uintptr, dstOff *int64, length int, flags int) (int, error) {
+	if copyFileRangeTrap == 0 {
+		return 0, syscall.ENOSYS
+	}
+	n, _, errno := syscall.Syscall6(copyFileRangeTrap, srcFd, uintptr(unsafe.Pointer(srcOff)), dstFd, uintptr(unsafe.Pointer(dstOff)), uintptr(length), uintptr(flags))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func (fs *fileSystemImpl) Sendfile(dstFd, srcFd uintptr, offset *int64, count int) (int, error) {
+	return syscall.Sendfile(int(dstFd), int(srcFd), offset, count)
+}
+
+func (fs *fileSystemImpl) Listxattr(path string, dest []byte) (int, error) {
+	return syscall.Listxattr(path, dest)
+}
+
+func (fs *fileSystemImpl) Getxattr(path, attr string, dest []byte) (int, error) {
+	return syscall.Getxattr(path, attr, dest)
+}
+
+func (fs *fileSystemImpl) Setxattr(path, attr string, data []byte, flags int) error {
+	return syscall.Setxattr(path, attr, data, flags)
+}
+
+func (fs *fileSystemImpl) Removexattr(path, attr string) error {
+	return syscall.Removexattr(path, attr)
+}
@@ -1,20 +1,26 @@
 package fio
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall"
 
 	"github.com/setlog/fio/fsi"
-	"github.com/setlog/panik"
 )
 
-func openFile(filePath string, flag int, perm fs.FileMode) (fsi.File, error) {
+func openFile(o *options, filePath string, flag int, perm fs.FileMode) (fsi.File, error) {
+	// O_TRUNC is applied only once the lock is held, so that files locked by others are left untouched.
+	truncate := flag&os.O_TRUNC != 0 && flag&accessModeMask != os.O_RDONLY
+	if truncate {
+		flag &^= os.O_TRUNC
+	}
 	haveLock := false
-	file, err := fsApi.OpenFile(filePath, flag, perm)
+	file, err := o.fs.OpenFile(filePath, flag, perm)
 	if err != nil {
 		return nil, err
 	}
@@ -23,73 +29,183 @@ func openFile(filePath string, flag int, perm fs.FileMode) (fsi.File, error) {
 			file.Close()
 		}
 	}()
-	err = lockForFlag(file.Fd(), flag)
+	err = lockForFlag(o, filePath, file, flag)
 	if err != nil {
-		return nil, fmt.Errorf("open '%s': %w", filePath, err)
+		return nil, err
 	}
 	haveLock = true
+	if truncate {
+		if err = truncateFile(file); err != nil {
+			closeFile(o, file)
+			return nil, err
+		}
+	}
 	return file, nil
 }
 
-func readFile(filePath string) []byte {
-	file, err := fsApi.OpenFile(filePath, os.O_RDONLY, 0660)
-	panik.OnError(err)
-	defer file.Close()
-	if err := lockForFlag(file.Fd(), os.O_RDONLY); err != nil {
-		panik.Panicf("open '%s': %w", filePath, err)
+// truncateFile truncates file to zero length. Files which cannot be truncated, such as character devices
+// and FIFOs, are left alone, like open(2) ignores O_TRUNC for them.
+func truncateFile(file fsi.File) error {
+	err := file.Truncate(0)
+	if errors.Is(err, syscall.EINVAL) {
+		if info, statErr := file.Stat(); statErr == nil && !info.Mode().IsRegular() {
+			return nil
+		}
 	}
-	data, err := ioutil.ReadAll(file)
-	panik.OnError(err)
-	return data
+	return err
 }
 
-func copyFile(fromFilePath, toFilePath string) (int64, error) {
-	fileInfo, err := fsApi.Stat(fromFilePath)
+func readFile(o *options, filePath string) ([]byte, error) {
+	file, err := openFile(o, filePath, os.O_RDONLY, 0660)
 	if err != nil {
-		return 0, fmt.Errorf("copy '%s' to '%s': stat source: %w", fromFilePath, toFilePath, err)
+		return nil, err
 	}
-	src, err := openFile(fromFilePath, os.O_RDONLY, 0660)
+	defer closeFile(o, file)
+	return ioutil.ReadAll(trackReader(file, o.ctx, nil))
+}
+
+func copyFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	src, err := openFile(o, fromFilePath, os.O_RDONLY, 0660)
+	if err != nil {
+		return 0, fmt.Errorf("open source: %w", err)
+	}
+	defer closeFile(o, src)
+	fileInfo, err := src.Stat()
 	if err != nil {
-		return 0, fmt.Errorf("copy '%s' to '%s': open source: %w", fromFilePath, toFilePath, err)
+		return 0, fmt.Errorf("stat source: %w", err)
 	}
-	defer src.Close()
 	var n int64
-	if n, err = writeFile(toFilePath, src, fileInfo.Mode().Perm()); err != nil {
-		return n, fmt.Errorf("copy '%s' to '%s': open destination: %w", fromFilePath, toFilePath, err)
+	if n, err = writeFile(o, toFilePath, newSourceFile(src, fromFilePath, fileInfo), fileInfo.Mode().Perm()); err != nil {
+		return n, fmt.Errorf("write destination: %w", err)
 	}
 	return n, nil
 }
 
-func moveFile(fromFilePath, toFilePath string) (int64, error) {
-	fileInfo, err := fsApi.Stat(fromFilePath)
+func moveFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	src, err := openFile(o, fromFilePath, os.O_RDONLY, 0660)
 	if err != nil {
-		return 0, fmt.Errorf("move '%s' to '%s': stat source: %w", fromFilePath, toFilePath, err)
+		return 0, fmt.Errorf("open source: %w", err)
+	}
+	defer closeFile(o, src)
+	fileInfo, err := src.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat source: %w", err)
+	}
+	renamed, err := renameLocked(o, fromFilePath, toFilePath)
+	if renamed {
+		o.result.Move = MoveRename
+		o.progress.begin(fileInfo.Size())
+		o.progress.add(fileInfo.Size())
+		if err == nil {
+			err = digestFile(o, src, fileInfo.Size())
+		}
+		return fileInfo.Size(), err
 	}
-	src, err := openFile(fromFilePath, os.O_RDONLY, 0660)
 	if err != nil {
-		return 0, fmt.Errorf("move '%s' to '%s': open source: %w", fromFilePath, toFilePath, err)
+		return 0, err
+	}
+	o.result.Move = MoveCopy
+	// The source is only removed once the destination is durable, so that a crash cannot lose both.
+	writeOptions := *o
+	if writeOptions.durability < DurabilityDir {
+		writeOptions.durability = DurabilityDir
 	}
-	defer src.Close()
 	var n int64
-	if n, err = writeFile(toFilePath, src, fileInfo.Mode().Perm()); err != nil {
-		return n, fmt.Errorf("move '%s' to '%s': open destination: %w", fromFilePath, toFilePath, err)
+	if n, err = writeFile(&writeOptions, toFilePath, newSourceFile(src, fromFilePath, fileInfo), fileInfo.Mode().Perm()); err != nil {
+		return n, fmt.Errorf("write destination: %w", err)
 	}
-	if err = fsApi.Remove(fromFilePath); err != nil {
-		return n, fmt.Errorf("move '%s' to '%s': Remove source: %w", fromFilePath, toFilePath, err)
+	if err = o.fs.Remove(fromFilePath); err != nil {
+		return n, fmt.Errorf("remove source: %w", err)
+	}
+	if o.durability >= DurabilityDir {
+		if err = syncDir(o, filepath.Dir(fromFilePath)); err != nil {
+			return n, fmt.Errorf("sync source directory: %w", err)
+		}
 	}
 	return n, nil
 }
 
-func writeFile(filePath string, reader io.Reader, perm fs.FileMode) (n int64, retErr error) {
+// renameLocked renames the file at fromFilePath to toFilePath while holding a write lock on the file it replaces, if any.
+// If toFilePath is a symbolic link, the file it points to is replaced, like when copying.
+// It returns false without renaming if the paths are on different mounts or toFilePath is not a regular file.
+func renameLocked(o *options, fromFilePath, toFilePath string) (bool, error) {
+	toFilePath, info, err := resolveSymlinks(o, toFilePath)
+	if err != nil {
+		return false, fmt.Errorf("resolve destination: %w", err)
+	}
+	if info != nil {
+		if !info.Mode().IsRegular() {
+			// Devices and pipes are written to rather than replaced.
+			return false, nil
+		}
+		dst, err := openFile(o, toFilePath, os.O_WRONLY, 0)
+		if err == nil {
+			defer closeFile(o, dst)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("lock destination: %w", err)
+		}
+	}
+	if err = o.fs.Rename(fromFilePath, toFilePath); err != nil {
+		if errors.Is(err, syscall.EXDEV) {
+			return false, nil
+		}
+		return false, fmt.Errorf("rename: %w", err)
+	}
+	if o.durability >= DurabilityDir {
+		if err = syncDir(o, filepath.Dir(toFilePath)); err != nil {
+			return true, fmt.Errorf("sync destination directory: %w", err)
+		}
+		if filepath.Dir(fromFilePath) != filepath.Dir(toFilePath) {
+			if err = syncDir(o, filepath.Dir(fromFilePath)); err != nil {
+				return true, fmt.Errorf("sync source directory: %w", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// maxSymlinks is the amount of symbolic links resolveSymlinks follows before failing with ELOOP, as on Linux.
+const maxSymlinks = 40
+
+// resolveSymlinks follows the symbolic links in the last element of filePath and returns the path
+// of the file they point to, so that renaming over it replaces that file instead of the link.
+// info describes the file and is nil if it does not exist.
+func resolveSymlinks(o *options, filePath string) (resolved string, info fs.FileInfo, err error) {
+	for i := 0; i <= maxSymlinks; i++ {
+		info, err = o.fs.Lstat(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return filePath, nil, nil
+		}
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			return filePath, info, err
+		}
+		target, err := o.fs.Readlink(filePath)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(target) {
+			// Cleaning the joined path could remove a ".." following a symbolic link to a directory.
+			target = filepath.Dir(filePath) + string(filepath.Separator) + target
+		}
+		filePath = target
+	}
+	return "", nil, &fs.PathError{Op: "resolve", Path: filePath, Err: syscall.ELOOP}
+}
+
+func writeFile(o *options, filePath string, reader io.Reader, perm fs.FileMode) (n int64, retErr error) {
+	o.progress.begin(totalSize(reader))
+	if o.atomic {
+		return writeFileAtomic(o, filePath, reader, perm)
+	}
 	finishedWriting := false
-	dst, err := openFile(filePath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, perm)
+	dst, created, err := openDestination(o, filePath, perm, !o.preserveOnFailure)
 	if err != nil {
 		return 0, err
 	}
 	defer func() {
-		dst.Close()
-		if !finishedWriting {
-			if remErr := fsApi.Remove(filePath); remErr != nil && !os.IsNotExist(remErr) {
+		closeFile(o, dst)
+		if !finishedWriting && created {
+			if remErr := o.fs.Remove(filePath); remErr != nil && !os.IsNotExist(remErr) {
 				if err != nil {
 					retErr = fmt.Errorf("%w. Then: %v", err, remErr)
 				} else {
@@ -98,50 +214,234 @@ func writeFile(filePath string, reader io.Reader, perm fs.FileMode) (n int64, re
 			}
 		}
 	}()
-	n, err = io.Copy(dst, reader)
+	src, isSourceFile := reader.(*sourceFile)
+	reader, hash := hashingReader(o, reader)
+	copyOptions := o
+	if o.preserveOnFailure && !created {
+		staged, err := stageContent(o, filePath, reader)
+		if err != nil {
+			return 0, fmt.Errorf("stage content: %w", err)
+		}
+		defer staged.Close()
+		if err = dst.Truncate(0); err != nil {
+			return 0, err
+		}
+		reader = staged
+		// The progress has been reported while staging the content.
+		unreported := *o
+		unreported.progress = nil
+		copyOptions = &unreported
+	}
+	n, err = copyData(copyOptions, dst, reader)
 	if err != nil {
 		return n, err
 	}
+	if err = verifyChecksum(o, dst, n, hash); err != nil {
+		return n, err
+	}
+	if isSourceFile {
+		if err = preserveMetadata(o, filePath, src); err != nil {
+			return n, err
+		}
+	}
+	if err = syncFile(o, dst, filePath, o.durability); err != nil {
+		return n, err
+	}
 	finishedWriting = true
 	return n, nil
 }
 
-func lockForFlag(fd uintptr, flag int) (err error) {
-	const mask = os.O_RDONLY | os.O_WRONLY | os.O_RDWR
-	accessMode := flag & mask
-	if accessMode == os.O_RDWR || accessMode == os.O_WRONLY {
-		err = fsApi.FcntlFlock(fd, syscall.F_SETLK, wrLock())
-		if err != nil {
-			err = fmt.Errorf("acquire write-lock: %w", err)
+// syncFile flushes file, which was opened from filePath, to stable storage as required by durability.
+func syncFile(o *options, file fsi.File, filePath string, durability Durability) error {
+	var err error
+	switch {
+	case durability == DurabilityData:
+		err = o.fs.Fdatasync(file.Fd())
+	case durability >= DurabilityFile:
+		err = file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	if durability >= DurabilityDir {
+		if err = syncDir(o, filepath.Dir(filePath)); err != nil {
+			return fmt.Errorf("sync directory: %w", err)
 		}
-		return err
-	} else if accessMode == os.O_RDONLY {
-		err = fsApi.FcntlFlock(fd, syscall.F_SETLK, rdLock())
-		if err != nil {
-			err = fmt.Errorf("acquire read-lock: %w", err)
+	}
+	return nil
+}
+
+// openDestination opens the file at filePath for writing, and for reading if o.checksum is set, and claims a write lock on it,
+// creating the file if it does not exist. created reports whether the file was created.
+// An existing file is truncated once the lock is held if truncate is true.
+func openDestination(o *options, filePath string, perm fs.FileMode, truncate bool) (file fsi.File, created bool, err error) {
+	accessMode := os.O_WRONLY
+	if o.checksum != nil {
+		// The written data is read back for verification.
+		accessMode = os.O_RDWR
+	}
+	flag := accessMode
+	if truncate {
+		flag |= os.O_TRUNC
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err = openFile(o, filePath, accessMode|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, os.ErrExist) {
+			return file, err == nil, err
 		}
+		file, err = openFile(o, filePath, flag, perm)
+		if !errors.Is(err, os.ErrNotExist) {
+			return file, false, err
+		}
+		// The file was removed after we failed to create it, or filePath is a dangling symbolic link. Try again.
+	}
+	// A dangling symbolic link makes both attempts fail forever. Create its target like os.Create would,
+	// but do not report it as created, since we cannot tell whether someone else created it meanwhile.
+	file, err = openFile(o, filePath, flag|os.O_CREATE, perm)
+	return file, false, err
+}
+
+// stageContent writes all data read from reader to a temporary file next to filePath
+// and returns a reader for it which removes the temporary file when closed.
+func stageContent(o *options, filePath string, reader io.Reader) (io.ReadCloser, error) {
+	dir, baseName := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+	temp, tempFilePath, err := createTempFile(o, dir, baseName, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(temp, trackReader(reader, o.ctx, o.progress))
+	if err != nil {
+		closeFile(o, temp)
+		o.fs.Remove(tempFilePath)
+		return nil, err
+	}
+	return &stagedContent{SectionReader: io.NewSectionReader(temp, 0, n), o: o, file: temp, filePath: tempFilePath}, nil
+}
+
+type stagedContent struct {
+	*io.SectionReader
+	o        *options
+	file     fsi.File
+	filePath string
+}
+
+func (s *stagedContent) Close() error {
+	closeFile(s.o, s.file)
+	return s.o.fs.Remove(s.filePath)
+}
+
+func lockHolder(o *options, filePath string) (*LockInfo, error) {
+	file, err := o.fs.OpenFile(filePath, os.O_RDONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return o.locker.Query(o.fs, file, WriteLock)
+}
+
+func readRange(o *options, filePath string, offset int64, n int) ([]byte, error) {
+	if offset < 0 || n < 0 {
+		return nil, fmt.Errorf("bad range of %d bytes at offset %d: %w", n, offset, syscall.EINVAL)
+	}
+	file, err := o.fs.OpenFile(filePath, os.O_RDONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err = lockRange(o, filePath, file, ReadLock, offset, int64(n)); err != nil {
+		return nil, err
+	}
+	defer unlockRange(o, file, offset, int64(n))
+	data := make([]byte, n)
+	read, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data[:read], nil
+}
+
+func writeRange(o *options, filePath string, offset int64, data []byte) error {
+	if offset < 0 {
+		return fmt.Errorf("bad offset %d: %w", offset, syscall.EINVAL)
+	}
+	file, err := o.fs.OpenFile(filePath, os.O_WRONLY, 0660)
+	if err != nil {
 		return err
 	}
-	return fmt.Errorf("acquire lock: bad access mode %d for flag %d", accessMode, flag)
+	defer file.Close()
+	if err = lockRange(o, filePath, file, WriteLock, offset, int64(len(data))); err != nil {
+		return err
+	}
+	defer unlockRange(o, file, offset, int64(len(data)))
+	_, err = file.WriteAt(data, offset)
+	return err
 }
 
-func rdLock() *syscall.Flock_t {
-	return lockWithType(syscall.F_RDLCK)
+// lockRange claims an advisory lock on a byte range of file using o.locker, which must implement RangeLocker.
+// If o.ctx is not nil, it waits for conflicting locks to be released until o.ctx is done.
+func lockRange(o *options, filePath string, file fsi.File, typ LockType, start, length int64) error {
+	locker, err := rangeLocker(o.locker)
+	if err != nil {
+		return err
+	}
+	if length == 0 {
+		// A length of 0 would lock to the end of the file.
+		return nil
+	}
+	if o.ctx != nil {
+		err = locker.LockRange(o.ctx, o.fs, file, typ, start, length)
+	} else {
+		err = locker.TryLockRange(o.fs, file, typ, start, length)
+	}
+	if err != nil {
+		return newLockError(filePath, typ, err, func() (*LockInfo, error) {
+			return locker.QueryRange(o.fs, file, typ, start, length)
+		})
+	}
+	return nil
 }
 
-func wrLock() *syscall.Flock_t {
-	return lockWithType(syscall.F_WRLCK)
+// unlockRange releases a lock claimed with lockRange. Unlocking is skipped for
+// lockers whose locks are released by closing the file anyway.
+func unlockRange(o *options, file fsi.File, start, length int64) {
+	if locker, ok := o.locker.(RangeLocker); ok && length != 0 && !isReleasedOnClose(locker) {
+		locker.UnlockRange(o.fs, file, start, length)
+	}
 }
 
-// func unlock() *syscall.Flock_t {
-// 	return lockWithType(syscall.F_UNLCK)
-// }
+// lockForFlag claims an advisory lock on file matching the access mode in flag using o.locker.
+// If o.ctx is not nil, it waits for conflicting locks to be released until o.ctx is done.
+func lockForFlag(o *options, filePath string, file fsi.File, flag int) error {
+	typ := lockTypeForFlag(flag)
+	if typ == NoLock {
+		return fmt.Errorf("acquire lock: bad access mode %d for flag %d", flag&accessModeMask, flag)
+	}
+	var err error
+	if o.ctx != nil {
+		err = o.locker.Lock(o.ctx, o.fs, file, typ)
+	} else {
+		err = o.locker.TryLock(o.fs, file, typ)
+	}
+	if err != nil {
+		return newLockError(filePath, typ, err, func() (*LockInfo, error) {
+			return o.locker.Query(o.fs, file, typ)
+		})
+	}
+	return nil
+}
 
-func lockWithType(typ int16) *syscall.Flock_t {
-	return &syscall.Flock_t{
-		Type:   typ,
-		Whence: io.SeekStart,
-		Start:  0,
-		Len:    0,
+// closeFile releases the lock claimed on file with o.locker and closes file.
+// Unlocking is skipped for lockers whose locks are released by closing the file anyway.
+func closeFile(o *options, file fsi.File) error {
+	var unlockErr error
+	if !isReleasedOnClose(o.locker) {
+		unlockErr = o.locker.Unlock(o.fs, file)
+	}
+	if err := file.Close(); err != nil {
+		return err
 	}
+	return unlockErr
 }
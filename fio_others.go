@@ -1,11 +1,14 @@
-//+build !linux
+//go:build !linux
+// +build !linux
 
 package fio
 
 import (
 	"io"
 	"io/fs"
-	"os"
+	"syscall"
+
+	"github.com/setlog/fio/fsi"
 )
 
 // This file only exists so developers on non-Linux operating systems can work
@@ -13,26 +16,89 @@ import (
 
 const errorMessage = "this is only implemented for Linux"
 
-func openFile(filePath string, flag int, perm gofs.FileMode) (fs.File, error) {
+func openFile(o *options, filePath string, flag int, perm fs.FileMode) (fsi.File, error) {
+	panic(errorMessage)
+}
+
+func readFile(o *options, filePath string) ([]byte, error) {
+	panic(errorMessage)
+}
+
+func copyFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	panic(errorMessage)
+}
+
+func moveFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	panic(errorMessage)
+}
+
+func writeFile(o *options, filePath string, reader io.Reader, perm fs.FileMode) (n int64, retErr error) {
+	panic(errorMessage)
+}
+
+func lockHolder(o *options, filePath string) (*LockInfo, error) {
+	panic(errorMessage)
+}
+
+func readRange(o *options, filePath string, offset int64, n int) ([]byte, error) {
+	panic(errorMessage)
+}
+
+func writeRange(o *options, filePath string, offset int64, data []byte) error {
 	panic(errorMessage)
 }
 
-func readFile(filePath string) []byte {
+func lockForFlag(o *options, filePath string, file fsi.File, flag int) error {
 	panic(errorMessage)
 }
 
-func copyFile(fromFilePath, toFilePath string) (int64, error) {
+func (fs *fileSystemImpl) Fdatasync(fd uintptr) error {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) IoctlFileClone(dstFd, srcFd uintptr) error {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) CopyFileRange(srcFd uintptr, srcOff *int64, dstFd uintptr, dstOff *int64, length int, flags int) (int, error) {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) Sendfile(dstFd, srcFd uintptr, offset *int64, count int) (int, error) {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) Listxattr(path string, dest []byte) (int, error) {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) Getxattr(path, attr string, dest []byte) (int, error) {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) Setxattr(path, attr string, data []byte, flags int) error {
+	panic(errorMessage)
+}
+
+func (fs *fileSystemImpl) Removexattr(path, attr string) error {
+	panic(errorMessage)
+}
+
+// errNoXattr is the error of the xattr system calls for attributes which do not exist.
+const errNoXattr = syscall.ENOATTR
+
+func listXattrsLocked(o *options, filePath string) ([]string, error) {
 	panic(errorMessage)
 }
 
-func moveFile(fromFilePath, toFilePath string) (int64, error) {
+func getXattrLocked(o *options, filePath, name string) ([]byte, error) {
 	panic(errorMessage)
 }
 
-func writeFile(filePath string, reader io.Reader, perm gofs.FileMode) (n int64, retErr error) {
+func setXattrLocked(o *options, filePath, name string, value []byte) error {
 	panic(errorMessage)
 }
 
-func lockForFlag(fd uintptr, flag int) (err error) {
+func removeXattrLocked(o *options, filePath, name string) error {
 	panic(errorMessage)
 }
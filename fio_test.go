@@ -4,9 +4,14 @@ package fio
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/setlog/fio/mock"
@@ -24,7 +29,7 @@ func TestOpenFileRead(t *testing.T) {
 
 	expectOpen(fsMock, fileMock, testSourceFileName, os.O_RDONLY)
 
-	openFile(testSourceFileName, os.O_RDONLY, 0660)
+	openFile(newOptions(nil), testSourceFileName, os.O_RDONLY, 0660)
 }
 
 func TestOpenFileWrite(t *testing.T) {
@@ -33,7 +38,26 @@ func TestOpenFileWrite(t *testing.T) {
 
 	expectOpen(fsMock, fileMock, testSourceFileName, os.O_WRONLY)
 
-	openFile(testSourceFileName, os.O_WRONLY, 0660)
+	openFile(newOptions(nil), testSourceFileName, os.O_WRONLY, 0660)
+}
+
+func TestOpenFileReturnsLockedFile(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := expectOpen(fsMock, fileMock, testSourceFileName, os.O_RDONLY)
+	fileMock.EXPECT().Close().Times(1).After(openCall)
+
+	file, err := TryOpenFile(testSourceFileName, os.O_RDONLY, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.File != fileMock || file.LockType() != ReadLock {
+		t.Fatalf("expected read-locked mock file, got %v on %v", file.LockType(), file.File)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
 }
 
 func TestReadFile(t *testing.T) {
@@ -44,7 +68,122 @@ func TestReadFile(t *testing.T) {
 	readCall := expectRead(fsMock, fileMock, []byte(testData)).After(openCall)
 	fileMock.EXPECT().Close().Times(1).After(readCall)
 
-	readFile(testSourceFileName)
+	readFile(newOptions(nil), testSourceFileName)
+}
+
+func TestReadFileLocked(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil)
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	lockCall := fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(rdLock())).Return(syscall.EAGAIN).After(openCall)
+	queryCall := expectLockQuery(fsMock, nextFd, rdLock(), 4242).After(lockCall)
+	fileMock.EXPECT().Close().Times(1).After(queryCall)
+	nextFd++
+
+	_, err := TryReadFile(testSourceFileName)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	var lockErr *LockError
+	if !errors.As(err, &lockErr) || lockErr.Path != testSourceFileName || lockErr.Type != ReadLock || lockErr.PID != 4242 {
+		t.Fatalf("expected *LockError for read-lock on '%s' held by PID 4242, got %v", testSourceFileName, err)
+	}
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != "read" || opErr.From != testSourceFileName {
+		t.Fatalf("expected *OpError for read of '%s', got %v", testSourceFileName, err)
+	}
+}
+func TestReadFileLockedWithEACCES(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil)
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	lockCall := fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(rdLock())).Return(syscall.EACCES).After(openCall)
+	queryCall := expectLockQuery(fsMock, nextFd, rdLock(), 4242).After(lockCall)
+	fileMock.EXPECT().Close().Times(1).After(queryCall)
+	nextFd++
+
+	_, err := TryReadFile(testSourceFileName)
+	if !errors.Is(err, ErrLocked) || !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("expected ErrLocked wrapping EACCES, got %v", err)
+	}
+}
+
+func TestLockHolder(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fd := nextFd
+	nextFd++
+	openCall := fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil)
+	fileMock.EXPECT().Fd().Return(fd).AnyTimes()
+	queryCall := expectLockQuery(fsMock, fd, wrLock(), 4242).After(openCall)
+	fileMock.EXPECT().Close().Times(1).After(queryCall)
+
+	holder := LockHolder(testSourceFileName)
+	if holder == nil || holder.PID != 4242 || holder.Type != WriteLock {
+		t.Fatalf("expected write-lock held by PID 4242, got %+v", holder)
+	}
+}
+
+func TestReadFileNotExist(t *testing.T) {
+	_, fsMock := prepareFileSystemMock(t)
+
+	fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).
+		Return(nil, &fs.PathError{Op: "open", Path: testSourceFileName, Err: syscall.ENOENT})
+
+	_, err := TryReadFile(testSourceFileName)
+	if !errors.Is(err, ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+	if errors.Is(err, ErrLocked) {
+		t.Fatalf("did not expect ErrLocked, got %v", err)
+	}
+}
+
+func TestReadFileContextWaitsForLock(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fd := nextFd
+	nextFd++
+	openCall := fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil)
+	fileMock.EXPECT().Fd().Return(fd).AnyTimes()
+	busyCall := fsMock.EXPECT().FcntlFlock(fd, syscall.F_SETLK, gomock.Eq(rdLock())).Times(2).Return(syscall.EAGAIN).After(openCall)
+	lockCall := fsMock.EXPECT().FcntlFlock(fd, syscall.F_SETLK, gomock.Eq(rdLock())).Return(nil).After(busyCall)
+	readCall := expectRead(fsMock, fileMock, []byte(testData)).After(lockCall)
+	fileMock.EXPECT().Close().Times(1).After(readCall)
+
+	data, err := TryReadFileContext(context.Background(), testSourceFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testData {
+		t.Fatalf("expected %q, got %q", testData, data)
+	}
+}
+
+func TestReadFileContextTimeout(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fd := nextFd
+	nextFd++
+	openCall := fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0660)).Return(fileMock, nil)
+	fileMock.EXPECT().Fd().Return(fd).AnyTimes()
+	lockCall := fsMock.EXPECT().FcntlFlock(fd, syscall.F_SETLK, gomock.Eq(rdLock())).MinTimes(1).Return(syscall.EAGAIN).After(openCall)
+	queryCall := expectLockQuery(fsMock, fd, rdLock(), 4242).After(lockCall)
+	fileMock.EXPECT().Close().Times(1).After(queryCall)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := TryReadFileContext(ctx, testSourceFileName)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected error matching context.DeadlineExceeded and ErrLocked, got %v", err)
+	}
 }
 
 func TestCopyFile(t *testing.T) {
@@ -52,43 +191,352 @@ func TestCopyFile(t *testing.T) {
 	srcFileMock := mock.NewMockFile(ctrl)
 	dstFileMock := mock.NewMockFile(ctrl)
 
-	statCall := expectStat(fsMock, srcFileMock)
-	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY).After(statCall)
-	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_WRONLY|os.O_TRUNC|os.O_CREATE).After(srcOpenCall)
-	readCall := expectRead(fsMock, srcFileMock, []byte(testData)).After(dstOpenCall)
-	writeCall := expectWrite(fsMock, dstFileMock, []byte(testData)).After(dstOpenCall)
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL).After(statCall)
+	kernelCopyCall := expectKernelCopyUnsupported(fsMock).After(dstOpenCall)
+	readCall := expectRead(fsMock, srcFileMock, []byte(testData)).After(kernelCopyCall)
+	writeCall := expectWrite(fsMock, dstFileMock, []byte(testData)).After(kernelCopyCall)
 	dstCloseCall := dstFileMock.EXPECT().Close().Times(1).After(writeCall).After(readCall)
 	srcFileMock.EXPECT().Close().Times(1).After(dstCloseCall)
 
-	copyFile(testSourceFileName, testDestinationFileName)
+	var result Result
+	CopyFile(testSourceFileName, testDestinationFileName, WithResult(&result))
+	if result.Copy != CopyBuffered {
+		t.Fatalf("expected %v, got %v", CopyBuffered, result.Copy)
+	}
 }
 
-func TestMoveFile(t *testing.T) {
+func TestCopyFileWithCopyFileRange(t *testing.T) {
 	ctrl, fsMock := prepareFileSystemMock(t)
 	srcFileMock := mock.NewMockFile(ctrl)
 	dstFileMock := mock.NewMockFile(ctrl)
 
-	statCall := expectStat(fsMock, srcFileMock)
-	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY).After(statCall)
-	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_WRONLY|os.O_TRUNC|os.O_CREATE).After(srcOpenCall)
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL).After(statCall)
+	cloneCall := fsMock.EXPECT().IoctlFileClone(gomock.Any(), gomock.Any()).Times(1).Return(syscall.EOPNOTSUPP).After(dstOpenCall)
+	copyCall := fsMock.EXPECT().CopyFileRange(gomock.Any(), nil, gomock.Any(), nil, gomock.Any(), 0).Times(1).Return(len(testData), nil).After(cloneCall)
+	eofCall := fsMock.EXPECT().CopyFileRange(gomock.Any(), nil, gomock.Any(), nil, gomock.Any(), 0).Times(1).Return(0, nil).After(copyCall)
+	dstCloseCall := dstFileMock.EXPECT().Close().Times(1).After(eofCall)
+	srcFileMock.EXPECT().Close().Times(1).After(dstCloseCall)
+
+	var result Result
+	n := CopyFile(testSourceFileName, testDestinationFileName, WithResult(&result))
+	if n != int64(len(testData)) || result.Copy != CopyFileRange {
+		t.Fatalf("expected %d bytes copied using %v, got %d using %v", len(testData), CopyFileRange, n, result.Copy)
+	}
+}
+
+func TestWriteFileLocksBeforeTruncating(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	gomock.InOrder(
+		expectOpenExisting(fsMock, testDestinationFileName),
+		fsMock.EXPECT().OpenFile(testDestinationFileName, os.O_WRONLY, os.FileMode(0660)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(wrLock())).Return(nil),
+		fileMock.EXPECT().Truncate(int64(0)).Return(nil),
+		expectWrite(fsMock, fileMock, []byte(testData)),
+		fileMock.EXPECT().Close(),
+	)
+	nextFd++
+
+	WriteFile(testDestinationFileName, []byte(testData))
+}
+
+func TestWriteFileToCharacterDevice(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	gomock.InOrder(
+		expectOpenExisting(fsMock, testDestinationFileName),
+		fsMock.EXPECT().OpenFile(testDestinationFileName, os.O_WRONLY, os.FileMode(0660)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(wrLock())).Return(nil),
+		fileMock.EXPECT().Truncate(int64(0)).Return(&fs.PathError{Op: "truncate", Path: testDestinationFileName, Err: syscall.EINVAL}),
+		fileMock.EXPECT().Stat().Return(&fileInfoImpl{name: testDestinationFileName, mode: fs.ModeDevice | fs.ModeCharDevice | 0666}, nil),
+		expectWrite(fsMock, fileMock, []byte(testData)),
+		fileMock.EXPECT().Close(),
+	)
+	nextFd++
+
+	WriteFile(testDestinationFileName, []byte(testData))
+}
+
+func TestWriteFileDoesNotTruncateLockedFile(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	gomock.InOrder(
+		expectOpenExisting(fsMock, testDestinationFileName),
+		fsMock.EXPECT().OpenFile(testDestinationFileName, os.O_WRONLY, os.FileMode(0660)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(wrLock())).Return(syscall.EAGAIN),
+		expectLockQuery(fsMock, nextFd, wrLock(), 4242),
+		fileMock.EXPECT().Close(),
+	)
+	nextFd++
+
+	if err := TryWriteFile(testDestinationFileName, []byte(testData)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestWriteFileRemovesCreatedFileOnFailure(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := expectOpen(fsMock, fileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	writeCall := fileMock.EXPECT().Write(gomock.Any()).Return(0, syscall.ENOSPC).After(openCall)
+	closeCall := fileMock.EXPECT().Close().After(writeCall)
+	fsMock.EXPECT().Remove(testDestinationFileName).Return(nil).After(closeCall)
+
+	if err := TryWriteFile(testDestinationFileName, []byte(testData)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+}
+
+func TestWriteFileKeepsExistingFileOnFailure(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	existingCall := expectOpenExisting(fsMock, testDestinationFileName)
+	openCall := expectOpen(fsMock, fileMock, testDestinationFileName, os.O_WRONLY).After(existingCall)
+	truncateCall := fileMock.EXPECT().Truncate(int64(0)).Return(nil).After(openCall)
+	writeCall := fileMock.EXPECT().Write(gomock.Any()).Return(0, syscall.ENOSPC).After(truncateCall)
+	fileMock.EXPECT().Close().After(writeCall)
+
+	if err := TryWriteFile(testDestinationFileName, []byte(testData)); !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+}
+
+func TestCopyFileWithChecksumMismatch(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	srcFileMock := mock.NewMockFile(ctrl)
+	dstFileMock := mock.NewMockFile(ctrl)
+
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_RDWR|os.O_CREATE|os.O_EXCL).After(statCall)
 	readCall := expectRead(fsMock, srcFileMock, []byte(testData)).After(dstOpenCall)
 	writeCall := expectWrite(fsMock, dstFileMock, []byte(testData)).After(dstOpenCall)
-	dstCloseCall := dstFileMock.EXPECT().Close().Times(1).After(writeCall).After(readCall)
+	readBackCall := dstFileMock.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(func(p []byte, off int64) (int, error) {
+		return copy(p, "Hello Wörld"), io.EOF
+	}).After(readCall).After(writeCall)
+	closeCall := dstFileMock.EXPECT().Close().Times(1).After(readBackCall)
+	removeCall := fsMock.EXPECT().Remove(testDestinationFileName).Times(1).Return(nil).After(closeCall)
+	srcFileMock.EXPECT().Close().Times(1).After(removeCall)
+
+	var result Result
+	_, err := TryCopyFile(testSourceFileName, testDestinationFileName, WithChecksum(CRC32C), WithResult(&result))
+	if !errors.Is(err, ErrChecksumMismatch) || result.Digest != nil {
+		t.Fatalf("expected ErrChecksumMismatch without digest, got %v and %x", err, result.Digest)
+	}
+}
+
+func TestWriteFileWithDurability(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := expectOpen(fsMock, fileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	writeCall := expectWrite(fsMock, fileMock, []byte(testData)).After(openCall)
+	syncCall := fsMock.EXPECT().Fdatasync(gomock.Any()).Times(1).Return(nil).After(writeCall)
+	fileMock.EXPECT().Close().Times(1).After(syncCall)
+
+	WriteFile(testDestinationFileName, []byte(testData), WithDurability(DurabilityData))
+}
+
+func TestWriteFileRemovesCreatedFileIfSyncFails(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	openCall := expectOpen(fsMock, fileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	writeCall := expectWrite(fsMock, fileMock, []byte(testData)).After(openCall)
+	syncCall := fileMock.EXPECT().Sync().Times(1).Return(syscall.EIO).After(writeCall)
+	closeCall := fileMock.EXPECT().Close().Times(1).After(syncCall)
+	fsMock.EXPECT().Remove(testDestinationFileName).Times(1).Return(nil).After(closeCall)
+
+	if err := TryWriteFile(testDestinationFileName, []byte(testData), WithDurability(DurabilityFile)); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected EIO, got %v", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	srcFileMock := mock.NewMockFile(ctrl)
+
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstLstatCall := expectLstatMissing(fsMock, testDestinationFileName).After(statCall)
+	renameCall := fsMock.EXPECT().Rename(testSourceFileName, testDestinationFileName).Times(1).Return(nil).After(dstLstatCall)
+	srcFileMock.EXPECT().Close().Times(1).After(renameCall)
+
+	var result Result
+	n := MoveFile(testSourceFileName, testDestinationFileName, WithResult(&result))
+	if n != int64(len(testData)) || result.Bytes != n || result.Move != MoveRename {
+		t.Fatalf("expected %d bytes moved using %v, got %d and %+v", len(testData), MoveRename, n, result)
+	}
+}
+
+func TestMoveFileAcrossMounts(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	srcFileMock := mock.NewMockFile(ctrl)
+	dstFileMock := mock.NewMockFile(ctrl)
+
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstLstatCall := expectLstatMissing(fsMock, testDestinationFileName).After(statCall)
+	renameCall := fsMock.EXPECT().Rename(testSourceFileName, testDestinationFileName).Times(1).
+		Return(&os.LinkError{Op: "rename", Old: testSourceFileName, New: testDestinationFileName, Err: syscall.EXDEV}).After(dstLstatCall)
+	dstOpenCall := expectOpen(fsMock, dstFileMock, testDestinationFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL).After(renameCall)
+	kernelCopyCall := expectKernelCopyUnsupported(fsMock).After(dstOpenCall)
+	readCall := expectRead(fsMock, srcFileMock, []byte(testData)).After(kernelCopyCall)
+	writeCall := expectWrite(fsMock, dstFileMock, []byte(testData)).After(kernelCopyCall)
+	syncCall := dstFileMock.EXPECT().Sync().Times(1).After(writeCall).After(readCall)
+	syncDirCall := expectSyncDir(ctrl, fsMock, ".").After(syncCall)
+	dstCloseCall := dstFileMock.EXPECT().Close().Times(1).After(syncDirCall)
 	srcRemoveCall := fsMock.EXPECT().Remove(testSourceFileName).After(dstCloseCall)
 	srcFileMock.EXPECT().Close().Times(1).After(srcRemoveCall)
 
-	moveFile(testSourceFileName, testDestinationFileName)
+	var result Result
+	n := MoveFile(testSourceFileName, testDestinationFileName, WithResult(&result))
+	if n != int64(len(testData)) || result.Bytes != n || result.Move != MoveCopy {
+		t.Fatalf("expected %d bytes moved using %v, got %d and %+v", len(testData), MoveCopy, n, result)
+	}
+}
+
+func TestMoveFileDoesNotReplaceLockedFile(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	srcFileMock := mock.NewMockFile(ctrl)
+	dstFileMock := mock.NewMockFile(ctrl)
+
+	srcOpenCall := expectOpen(fsMock, srcFileMock, testSourceFileName, os.O_RDONLY)
+	statCall := expectStat(srcFileMock).After(srcOpenCall)
+	dstLstatCall := fsMock.EXPECT().Lstat(testDestinationFileName).Times(1).Return(&fileInfoImpl{name: testDestinationFileName, mode: 0660}, nil).After(statCall)
+	dstOpenCall := fsMock.EXPECT().OpenFile(testDestinationFileName, os.O_WRONLY, os.FileMode(0)).Times(1).Return(dstFileMock, nil).After(dstLstatCall)
+	dstFileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	lockCall := fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(wrLock())).Return(syscall.EAGAIN).After(dstOpenCall)
+	queryCall := expectLockQuery(fsMock, nextFd, wrLock(), 4242).After(lockCall)
+	dstCloseCall := dstFileMock.EXPECT().Close().Times(1).After(queryCall)
+	srcFileMock.EXPECT().Close().Times(1).After(dstCloseCall)
+	nextFd++
+
+	if _, err := TryMoveFile(testSourceFileName, testDestinationFileName); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestSetXattrLocksFile(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	gomock.InOrder(
+		fsMock.EXPECT().OpenFile(testSourceFileName, os.O_WRONLY, os.FileMode(0)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(wrLock())).Return(nil),
+		fsMock.EXPECT().Setxattr(testSourceFileName, "user.state", []byte("done"), 0).Return(nil),
+		fileMock.EXPECT().Close(),
+	)
+	nextFd++
+
+	SetXattr(testSourceFileName, "user.state", []byte("done"))
+}
+
+func TestGetXattrNotExist(t *testing.T) {
+	ctrl, fsMock := prepareFileSystemMock(t)
+	fileMock := mock.NewMockFile(ctrl)
+
+	fileMock.EXPECT().Fd().Return(nextFd).AnyTimes()
+	gomock.InOrder(
+		fsMock.EXPECT().OpenFile(testSourceFileName, os.O_RDONLY, os.FileMode(0)).Return(fileMock, nil),
+		fsMock.EXPECT().FcntlFlock(nextFd, syscall.F_SETLK, gomock.Eq(rdLock())).Return(nil),
+		fsMock.EXPECT().Getxattr(testSourceFileName, "user.state", nil).Return(0, errNoXattr),
+		fileMock.EXPECT().Close(),
+	)
+	nextFd++
+
+	if _, err := TryGetXattr(testSourceFileName, "user.state"); !errors.Is(err, ErrNoXattr) {
+		t.Fatalf("expected ErrNoXattr, got %v", err)
+	}
+}
+
+func TestRangeRejectsNegativeValues(t *testing.T) {
+	prepareFileSystemMock(t)
+
+	if _, err := TryReadRange(testSourceFileName, 5, -2); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL for negative length, got %v", err)
+	}
+	if _, err := TryReadRange(testSourceFileName, -1, 2); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL for negative offset, got %v", err)
+	}
+	if err := TryWriteRange(testSourceFileName, -1, []byte(testData)); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL for negative offset, got %v", err)
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	_, fsMock := prepareFileSystemMock(t)
+
+	fsMock.EXPECT().Remove(testSourceFileName).Times(1).Return(nil)
+
+	if !RemoveFile(testSourceFileName) {
+		t.Fatal("expected file to be removed")
+	}
+}
+
+func TestRemoveFileNotExist(t *testing.T) {
+	_, fsMock := prepareFileSystemMock(t)
+
+	fsMock.EXPECT().Remove(testSourceFileName).Times(1).Return(&fs.PathError{Op: "remove", Path: testSourceFileName, Err: syscall.ENOENT})
+
+	if removed, err := TryRemoveFile(testSourceFileName); removed || err != nil {
+		t.Fatalf("expected nothing to be removed, got %v and %v", removed, err)
+	}
+}
+
+func TestRenameFile(t *testing.T) {
+	_, fsMock := prepareFileSystemMock(t)
+
+	fsMock.EXPECT().Rename(testSourceFileName, testDestinationFileName).Times(1).Return(nil)
+
+	RenameFile(testSourceFileName, testDestinationFileName)
+}
+
+func TestRenameFileAcrossMounts(t *testing.T) {
+	_, fsMock := prepareFileSystemMock(t)
+
+	fsMock.EXPECT().Rename(testSourceFileName, testDestinationFileName).Times(1).
+		Return(&os.LinkError{Op: "rename", Old: testSourceFileName, New: testDestinationFileName, Err: syscall.EXDEV})
+
+	err := TryRenameFile(testSourceFileName, testDestinationFileName)
+	var opErr *OpError
+	if !errors.Is(err, syscall.EXDEV) || !errors.As(err, &opErr) || opErr.Op != "rename" {
+		t.Fatalf("expected rename *OpError wrapping EXDEV, got %v", err)
+	}
+}
+
+func rdLock() *syscall.Flock_t {
+	return lockWithRange(syscall.F_RDLCK, 0, 0)
+}
+
+func wrLock() *syscall.Flock_t {
+	return lockWithRange(syscall.F_WRLCK, 0, 0)
 }
 
 func prepareFileSystemMock(t *testing.T) (*gomock.Controller, *mock.MockFileSystem) {
 	ctrl := gomock.NewController(t)
 	fsMock := mock.NewMockFileSystem(ctrl)
+	previousFsApi := fsApi
 	fsApi = fsMock
+	t.Cleanup(func() { fsApi = previousFsApi })
 	return ctrl, fsMock
 }
 
-func expectStat(fsMock *mock.MockFileSystem, fileMock *mock.MockFile) *gomock.Call {
-	return fsMock.EXPECT().Stat(testSourceFileName).Times(1).Return(&fileInfoImpl{
+func expectStat(fileMock *mock.MockFile) *gomock.Call {
+	return fileMock.EXPECT().Stat().Times(1).Return(&fileInfoImpl{
 		name: testSourceFileName, size: int64(len(testData)), mode: 0660,
 	}, nil)
 }
@@ -97,14 +545,45 @@ func expectOpen(fsMock *mock.MockFileSystem, fileMock *mock.MockFile, name strin
 	fd := nextFd
 	nextFd++
 	openCall := fsMock.EXPECT().OpenFile(name, flag, os.FileMode(0660)).Times(1).Return(fileMock, nil)
-	fdCall := fileMock.EXPECT().Fd().Return(fd).After(openCall)
+	fileMock.EXPECT().Fd().Return(fd).AnyTimes()
 	var lk *syscall.Flock_t
 	if (flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)) == os.O_RDONLY {
 		lk = rdLock()
 	} else {
 		lk = wrLock()
 	}
-	return fsMock.EXPECT().FcntlFlock(fd, syscall.F_SETLK, gomock.Eq(lk)).Times(1).Return(nil).After(fdCall)
+	return fsMock.EXPECT().FcntlFlock(fd, syscall.F_SETLK, gomock.Eq(lk)).Times(1).Return(nil).After(openCall)
+}
+
+func expectSyncDir(ctrl *gomock.Controller, fsMock *mock.MockFileSystem, dir string) *gomock.Call {
+	dirMock := mock.NewMockFile(ctrl)
+	openCall := fsMock.EXPECT().OpenFile(dir, os.O_RDONLY, os.FileMode(0)).Times(1).Return(dirMock, nil)
+	syncCall := dirMock.EXPECT().Sync().Times(1).Return(nil).After(openCall)
+	return dirMock.EXPECT().Close().Times(1).Return(nil).After(syncCall)
+}
+
+func expectKernelCopyUnsupported(fsMock *mock.MockFileSystem) *gomock.Call {
+	cloneCall := fsMock.EXPECT().IoctlFileClone(gomock.Any(), gomock.Any()).Times(1).Return(syscall.EOPNOTSUPP)
+	copyCall := fsMock.EXPECT().CopyFileRange(gomock.Any(), nil, gomock.Any(), nil, gomock.Any(), 0).Times(1).Return(0, syscall.EXDEV).After(cloneCall)
+	return fsMock.EXPECT().Sendfile(gomock.Any(), gomock.Any(), nil, gomock.Any()).Times(1).Return(0, syscall.EINVAL).After(copyCall)
+}
+
+func expectLstatMissing(fsMock *mock.MockFileSystem, name string) *gomock.Call {
+	return fsMock.EXPECT().Lstat(name).Times(1).
+		Return(nil, &fs.PathError{Op: "lstat", Path: name, Err: syscall.ENOENT})
+}
+
+func expectOpenExisting(fsMock *mock.MockFileSystem, name string) *gomock.Call {
+	return fsMock.EXPECT().OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(0660)).Times(1).
+		Return(nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EEXIST})
+}
+
+func expectLockQuery(fsMock *mock.MockFileSystem, fd uintptr, lk *syscall.Flock_t, holderPid int32) *gomock.Call {
+	return fsMock.EXPECT().FcntlFlock(fd, syscall.F_GETLK, gomock.Eq(lk)).Times(1).DoAndReturn(func(fd uintptr, cmd int, lk *syscall.Flock_t) error {
+		lk.Type = syscall.F_WRLCK
+		lk.Pid = holderPid
+		return nil
+	})
 }
 
 func expectRead(fsMock *mock.MockFileSystem, fileMock *mock.MockFile, data []byte) *gomock.Call {
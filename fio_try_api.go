@@ -0,0 +1,213 @@
+package fio
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// The functions in this file are the error-returning counterparts of the functions in fio_api.go.
+// They claim the same advisory locks and log the same messages, but return errors instead of
+// panicking, which makes them suitable for library code which must not rely on recover().
+
+// TryOpenFile is like OpenFile, but returns an error instead of panicking.
+func TryOpenFile(filePath string, flag int, perm fs.FileMode, opts ...Option) (*LockedFile, error) {
+	return defaultClient.TryOpenFile(filePath, flag, perm, opts...)
+}
+
+// TryOpenFileContext is like OpenFileContext, but returns an error instead of panicking.
+func TryOpenFileContext(ctx context.Context, filePath string, flag int, perm fs.FileMode, opts ...Option) (*LockedFile, error) {
+	return defaultClient.TryOpenFileContext(ctx, filePath, flag, perm, opts...)
+}
+
+func tryOpenFile(o *options, filePath string, flag int, perm fs.FileMode) (*LockedFile, error) {
+	file, err := openFile(o, filePath, flag, perm)
+	if err != nil {
+		return nil, newOpError("open", filePath, "", err)
+	}
+	return newLockedFile(o, file, filePath, lockTypeForFlag(flag)), nil
+}
+
+// TryReadFile is like ReadFile, but returns an error instead of panicking.
+func TryReadFile(filePath string, opts ...Option) ([]byte, error) {
+	return defaultClient.TryReadFile(filePath, opts...)
+}
+
+// TryReadFileContext is like ReadFileContext, but returns an error instead of panicking.
+func TryReadFileContext(ctx context.Context, filePath string, opts ...Option) ([]byte, error) {
+	return defaultClient.TryReadFileContext(ctx, filePath, opts...)
+}
+
+func tryReadFile(o *options, filePath string) ([]byte, error) {
+	data, err := readFile(o, filePath)
+	if err != nil {
+		return nil, newOpError("read", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Read '%s'.", filePath)
+	}
+	return data, nil
+}
+
+// TryMoveFile is like MoveFile, but returns an error instead of panicking.
+func TryMoveFile(fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return defaultClient.TryMoveFile(fromFilePath, toFilePath, opts...)
+}
+
+// TryMoveFileContext is like MoveFileContext, but returns an error instead of panicking.
+func TryMoveFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return defaultClient.TryMoveFileContext(ctx, fromFilePath, toFilePath, opts...)
+}
+
+func tryMoveFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	n, err := moveFile(o, fromFilePath, toFilePath)
+	o.result.Bytes = n
+	if err != nil {
+		return n, newOpError("move", fromFilePath, toFilePath, err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Moved '%s' to '%s' using %v.", fromFilePath, toFilePath, o.result.Move)
+	}
+	o.progress.finish()
+	return n, nil
+}
+
+// TryRenameFile is a shorthand for os.Rename(fromFilePath, toFilePath) with the error wrapped in an *OpError.
+//
+// If fromFilePath and toFilePath are on different mounts, consider using TryMoveFile() instead.
+func TryRenameFile(fromFilePath, toFilePath string, opts ...Option) error {
+	return defaultClient.TryRenameFile(fromFilePath, toFilePath, opts...)
+}
+
+// TryCopyFile is like CopyFile, but returns an error instead of panicking.
+func TryCopyFile(fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return defaultClient.TryCopyFile(fromFilePath, toFilePath, opts...)
+}
+
+// TryCopyFileContext is like CopyFileContext, but returns an error instead of panicking.
+func TryCopyFileContext(ctx context.Context, fromFilePath, toFilePath string, opts ...Option) (int64, error) {
+	return defaultClient.TryCopyFileContext(ctx, fromFilePath, toFilePath, opts...)
+}
+
+func tryCopyFile(o *options, fromFilePath, toFilePath string) (int64, error) {
+	n, err := copyFile(o, fromFilePath, toFilePath)
+	o.result.Bytes = n
+	if err != nil {
+		return n, newOpError("copy", fromFilePath, toFilePath, err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Copied '%s' to '%s' using %v.", fromFilePath, toFilePath, o.result.Copy)
+	}
+	o.progress.finish()
+	return n, nil
+}
+
+// TryWriteFile is like WriteFile, but returns an error instead of panicking.
+func TryWriteFile(filePath string, data []byte, opts ...Option) error {
+	return defaultClient.TryWriteFile(filePath, data, opts...)
+}
+
+// TryWriteFileContext is like WriteFileContext, but returns an error instead of panicking.
+func TryWriteFileContext(ctx context.Context, filePath string, data []byte, opts ...Option) error {
+	return defaultClient.TryWriteFileContext(ctx, filePath, data, opts...)
+}
+
+// TryWriteFilePerm is like WriteFilePerm, but returns an error instead of panicking.
+func TryWriteFilePerm(filePath string, data []byte, perm fs.FileMode, opts ...Option) error {
+	return defaultClient.TryWriteFilePerm(filePath, data, perm, opts...)
+}
+
+// TryWriteFileWithReader is like WriteFileWithReader, but returns an error instead of panicking.
+func TryWriteFileWithReader(filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	return defaultClient.TryWriteFileWithReader(filePath, reader, opts...)
+}
+
+// TryWriteFileWithReaderContext is like WriteFileWithReaderContext, but returns an error instead of panicking.
+func TryWriteFileWithReaderContext(ctx context.Context, filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	return defaultClient.TryWriteFileWithReaderContext(ctx, filePath, reader, opts...)
+}
+
+// TryWriteFileWithReaderPerm is like WriteFileWithReaderPerm, but returns an error instead of panicking.
+func TryWriteFileWithReaderPerm(filePath string, reader io.Reader, perm os.FileMode, opts ...Option) (int64, error) {
+	return defaultClient.TryWriteFileWithReaderPerm(filePath, reader, perm, opts...)
+}
+
+// TryWriteFileAtomic is like WriteFileAtomic, but returns an error instead of panicking.
+func TryWriteFileAtomic(filePath string, data []byte, opts ...Option) error {
+	return defaultClient.TryWriteFileAtomic(filePath, data, opts...)
+}
+
+// TryWriteFileWithReaderAtomic is like WriteFileWithReaderAtomic, but returns an error instead of panicking.
+func TryWriteFileWithReaderAtomic(filePath string, reader io.Reader, opts ...Option) (int64, error) {
+	return defaultClient.TryWriteFileWithReaderAtomic(filePath, reader, opts...)
+}
+
+func tryWriteFile(o *options, filePath string, reader io.Reader, perm fs.FileMode) (int64, error) {
+	n, err := writeFile(o, filePath, reader, perm)
+	o.result.Bytes = n
+	if err != nil {
+		return n, newOpError("write", filePath, "", err)
+	}
+	if log := o.log; log != nil {
+		log.Printf("Wrote '%s'.", filePath)
+	}
+	o.progress.finish()
+	return n, nil
+}
+
+// TryReadRange is like ReadRange, but returns an error instead of panicking.
+func TryReadRange(filePath string, offset int64, n int, opts ...Option) ([]byte, error) {
+	return defaultClient.TryReadRange(filePath, offset, n, opts...)
+}
+
+// TryWriteRange is like WriteRange, but returns an error instead of panicking.
+func TryWriteRange(filePath string, offset int64, data []byte, opts ...Option) error {
+	return defaultClient.TryWriteRange(filePath, offset, data, opts...)
+}
+
+// TryIsLocked is like IsLocked, but returns an error instead of panicking.
+func TryIsLocked(filePath string, opts ...Option) (bool, error) {
+	return defaultClient.TryIsLocked(filePath, opts...)
+}
+
+// TryLockHolder is like LockHolder, but returns an error instead of panicking.
+func TryLockHolder(filePath string, opts ...Option) (*LockInfo, error) {
+	return defaultClient.TryLockHolder(filePath, opts...)
+}
+
+// TryWaitUntilUnlocked is like WaitUntilUnlocked, but returns an error instead of panicking.
+func TryWaitUntilUnlocked(ctx context.Context, filePath string, opts ...Option) error {
+	return defaultClient.TryWaitUntilUnlocked(ctx, filePath, opts...)
+}
+
+// TryWaitUntilStable is like WaitUntilStable, but returns an error instead of panicking.
+func TryWaitUntilStable(ctx context.Context, filePath string, quietPeriod time.Duration, opts ...Option) error {
+	return defaultClient.TryWaitUntilStable(ctx, filePath, quietPeriod, opts...)
+}
+
+// TryListXattr is like ListXattr, but returns an error instead of panicking.
+func TryListXattr(filePath string, opts ...Option) ([]string, error) {
+	return defaultClient.TryListXattr(filePath, opts...)
+}
+
+// TryGetXattr is like GetXattr, but returns an error instead of panicking.
+func TryGetXattr(filePath, name string, opts ...Option) ([]byte, error) {
+	return defaultClient.TryGetXattr(filePath, name, opts...)
+}
+
+// TrySetXattr is like SetXattr, but returns an error instead of panicking.
+func TrySetXattr(filePath, name string, value []byte, opts ...Option) error {
+	return defaultClient.TrySetXattr(filePath, name, value, opts...)
+}
+
+// TryRemoveXattr is like RemoveXattr, but returns an error instead of panicking.
+func TryRemoveXattr(filePath, name string, opts ...Option) error {
+	return defaultClient.TryRemoveXattr(filePath, name, opts...)
+}
+
+// TryRemoveFile is like RemoveFile, but returns an error instead of panicking.
+func TryRemoveFile(filePath string, opts ...Option) (bool, error) {
+	return defaultClient.TryRemoveFile(filePath, opts...)
+}
@@ -4,17 +4,43 @@ import (
 	"io"
 	"os"
 	"syscall"
+	"time"
 )
 
 type File interface {
 	io.ReadWriteCloser
+	io.ReaderAt
+	io.WriterAt
+	io.Seeker
 	Fd() uintptr
 	Name() string
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
 }
 
 type FileSystem interface {
 	OpenFile(name string, flag int, perm os.FileMode) (File, error)
 	FcntlFlock(fd uintptr, cmd int, lk *syscall.Flock_t) error
+	Flock(fd uintptr, how int) error
+	Fdatasync(fd uintptr) error
+	IoctlFileClone(dstFd, srcFd uintptr) error
+	CopyFileRange(srcFd uintptr, srcOff *int64, dstFd uintptr, dstOff *int64, length int, flags int) (int, error)
+	Sendfile(dstFd, srcFd uintptr, offset *int64, count int) (int, error)
 	Remove(name string) error
+	Rename(oldpath, newpath string) error
 	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Mkdir(name string, perm os.FileMode) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	Link(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode os.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Listxattr(path string, dest []byte) (int, error)
+	Getxattr(path, attr string, dest []byte) (int, error)
+	Setxattr(path, attr string, data []byte, flags int) error
+	Removexattr(path, attr string) error
 }
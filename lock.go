@@ -0,0 +1,91 @@
+package fio
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// LockType is the type of an advisory lock.
+type LockType int16
+
+const (
+	// ReadLock is a shared lock. Any number of processes may hold a read lock on a file at the same time.
+	ReadLock LockType = syscall.F_RDLCK
+	// WriteLock is an exclusive lock. While a process holds a write lock on a file, no other process may hold any lock on it.
+	WriteLock LockType = syscall.F_WRLCK
+	// NoLock denotes the absence of a lock.
+	NoLock LockType = syscall.F_UNLCK
+)
+
+func (t LockType) String() string {
+	switch t {
+	case ReadLock:
+		return "read-lock"
+	case WriteLock:
+		return "write-lock"
+	case NoLock:
+		return "no lock"
+	}
+	return "unknown lock"
+}
+
+const accessModeMask = os.O_RDONLY | os.O_WRONLY | os.O_RDWR
+
+// lockTypeForFlag returns the lock type matching the access mode in flag
+// as used with os.OpenFile, or NoLock if the access mode is invalid.
+func lockTypeForFlag(flag int) LockType {
+	switch flag & accessModeMask {
+	case os.O_RDONLY:
+		return ReadLock
+	case os.O_WRONLY, os.O_RDWR:
+		return WriteLock
+	}
+	return NoLock
+}
+
+// LockInfo describes an advisory lock held on a file.
+type LockInfo struct {
+	Type  LockType // The type of the lock.
+	Start int64    // The offset of the first locked byte.
+	Len   int64    // The amount of locked bytes. 0 means the lock extends to the end of the file, however large it grows.
+	PID   int      // The ID of the process holding the lock; 0 if unknown and -1 for descriptor-owned locks, such as OFD locks.
+}
+
+const (
+	minLockRetryInterval = 10 * time.Millisecond
+	maxLockRetryInterval = time.Second
+)
+
+// waitForLock calls tryLock until it succeeds, fails for a reason other than a conflicting lock,
+// or ctx is done. The interval between attempts doubles with every attempt, up to maxLockRetryInterval.
+func waitForLock(ctx context.Context, tryLock func() error) error {
+	interval := minLockRetryInterval
+	for {
+		err := tryLock()
+		if err == nil || !isLockConflict(err) {
+			return err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if interval *= 2; interval > maxLockRetryInterval {
+			interval = maxLockRetryInterval
+		}
+	}
+}
+
+func lockWithRange(typ int16, start, length int64) *syscall.Flock_t {
+	return &syscall.Flock_t{
+		Type:   typ,
+		Whence: io.SeekStart,
+		Start:  start,
+		Len:    length,
+	}
+}
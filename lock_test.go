@@ -0,0 +1,458 @@
+package fio
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOFDLocksExcludeDescriptorsOfSameProcess(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	defer file.Close()
+
+	_, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	_, err = TryOpenFile(filePath, os.O_RDONLY, 0660, WithLocker(OFDLocker{}))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestOFDReadLocksAreShared(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDONLY, 0660, WithLocker(OFDLocker{}))
+	defer file.Close()
+
+	otherFile, err := TryOpenFile(filePath, os.O_RDONLY, 0660, WithLocker(OFDLocker{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFile.Close()
+}
+
+func TestOFDLockSurvivesClosingOtherDescriptor(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	defer file.Close()
+	unrelated, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelated.Close()
+
+	_, err = TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestFcntlLocksAreSharedWithinProcess(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, WithLocker(FcntlLocker{}))
+	defer file.Close()
+
+	otherFile, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(FcntlLocker{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFile.Close()
+}
+
+func TestFlockLocksInteroperateWithFlock(t *testing.T) {
+	filePath := createTestFile(t)
+
+	foreign, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	if err := syscall.Flock(int(foreign.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TryReadFile(filePath, WithLocker(FlockLocker{})); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if _, err := TryReadFile(filePath, WithLocker(MultiLocker{FcntlLocker{}, FlockLocker{}})); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if _, err := TryReadFile(filePath, WithLocker(FcntlLocker{})); err != nil {
+		t.Fatalf("expected flock(2) lock to be invisible to fcntl(2), got %v", err)
+	}
+}
+
+func TestMultiLockerFailsIfAnyLockIsHeld(t *testing.T) {
+	filePath := createTestFile(t)
+
+	foreign, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	if err := syscall.Flock(int(foreign.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(MultiLocker{OFDLocker{}, FlockLocker{}})); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	file, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	if err != nil {
+		t.Fatalf("expected flock(2) lock to be invisible to OFD locks, got %v", err)
+	}
+	file.Close()
+}
+
+func TestMultiLockerReleasesClaimedLocksOnFailure(t *testing.T) {
+	filePath := createTestFile(t)
+	if err := os.WriteFile(filePath+".lock", []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = MultiLocker{OFDLocker{}, LockFileLocker{}}.TryLock(fsApi, file, WriteLock)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	otherFile, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	if err != nil {
+		t.Fatalf("expected OFD lock to have been released, got %v", err)
+	}
+	otherFile.Close()
+}
+
+func TestLockFileLocker(t *testing.T) {
+	filePath := createTestFile(t)
+	lockFilePath := filePath + ".lck"
+	locker := LockFileLocker{Suffix: ".lck"}
+
+	WriteFile(filePath, []byte(testData), WithLocker(locker))
+	if _, err := os.Stat(lockFilePath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock file to have been removed, got %v", err)
+	}
+
+	if err := os.WriteFile(lockFilePath, []byte("4242\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryReadFile(filePath, WithLocker(locker)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := locker.Query(fsApi, file, ReadLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.PID != 4242 || info.Type != WriteLock {
+		t.Fatalf("expected write-lock held by PID 4242, got %+v", info)
+	}
+}
+
+func TestNoLockerIgnoresLocks(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{}))
+	defer file.Close()
+
+	if _, err := TryReadFile(filePath, WithLocker(NoLocker{})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockedFileConvertsAndReleasesLock(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, ofd)
+	defer file.Close()
+	if file.Path() != filePath || file.LockType() != WriteLock {
+		t.Fatalf("expected write-lock on '%s', got %v on '%s'", filePath, file.LockType(), file.Path())
+	}
+	expectReadLocked := func(locked bool) {
+		t.Helper()
+		other, err := TryOpenFile(filePath, os.O_RDONLY, 0660, ofd)
+		if locked != errors.Is(err, ErrLocked) {
+			t.Fatalf("expected locked=%v, got %v", locked, err)
+		}
+		if err == nil {
+			other.Close()
+		}
+	}
+	expectReadLocked(true)
+
+	if err := file.DowngradeToRead(); err != nil {
+		t.Fatal(err)
+	}
+	expectReadLocked(false)
+
+	reader := OpenFile(filePath, os.O_RDONLY, 0660, ofd)
+	if err := file.UpgradeToWrite(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if file.LockType() != ReadLock {
+		t.Fatalf("expected read-lock to be retained, got %v", file.LockType())
+	}
+	reader.Close()
+	if err := file.UpgradeToWrite(); err != nil {
+		t.Fatal(err)
+	}
+	expectReadLocked(true)
+
+	if err := file.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if file.LockType() != NoLock {
+		t.Fatalf("expected no lock, got %v", file.LockType())
+	}
+	expectReadLocked(false)
+	if _, err := file.Write([]byte(testData)); err != nil {
+		t.Fatalf("expected file to remain usable after unlocking, got %v", err)
+	}
+}
+
+func TestLockedFileKeepsLockAfterFailedConversion(t *testing.T) {
+	filePath := createTestFile(t)
+	multi := WithLocker(MultiLocker{OFDLocker{}, FlockLocker{}})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, multi)
+	defer file.Close()
+	if err := file.DowngradeToRead(); err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	if err := syscall.Flock(int(foreign.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := file.UpgradeToWrite(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if file.LockType() != ReadLock {
+		t.Fatalf("expected read-lock to be retained, got %v", file.LockType())
+	}
+	if _, err := TryOpenFile(filePath, os.O_RDWR, 0660, WithLocker(OFDLocker{})); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected OFD read-lock to be retained, got %v", err)
+	}
+	if err := syscall.Flock(int(foreign.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Fatalf("expected flock(2) read-lock to be retained, got %v", err)
+	}
+}
+
+func TestLockedFileCloseRemovesLockFile(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDONLY, 0660, WithLocker(LockFileLocker{}))
+	if _, err := os.Stat(filePath + ".lock"); err != nil {
+		t.Fatalf("expected lock file to exist, got %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filePath + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected lock file to have been removed, got %v", err)
+	}
+}
+
+func TestLockFileLockerConvertsOwnLock(t *testing.T) {
+	filePath := createTestFile(t)
+	locker := WithLocker(LockFileLocker{})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, locker)
+	defer file.Close()
+	if err := file.DowngradeToRead(); err != nil {
+		t.Fatalf("expected owner to downgrade its lock, got %v", err)
+	}
+	if err := file.UpgradeToWrite(); err != nil {
+		t.Fatalf("expected owner to upgrade its lock, got %v", err)
+	}
+	if _, err := TryOpenFile(filePath, os.O_RDONLY, 0660, locker); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if err := file.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	other := OpenFile(filePath, os.O_RDONLY, 0660, locker)
+	other.Close()
+}
+
+func TestRangeLocks(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, ofd)
+	defer file.Close()
+	if err := file.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	other := OpenFile(filePath, os.O_RDWR, 0660, ofd)
+	defer other.Close()
+	if err := other.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.LockRange(WriteLock, 0, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TryReadRange(filePath, 2, 4, ofd); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if data := ReadRange(filePath, 6, 5, ofd); string(data) != testData[6:] {
+		t.Fatalf("expected %q, got %q", testData[6:], data)
+	}
+	WriteRange(filePath, 6, []byte("Gophe"), ofd)
+	if data := ReadRange(filePath, 6, 100, ofd); string(data) != "Gophe" {
+		t.Fatalf("expected %q, got %q", "Gophe", data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := other.LockRangeContext(ctx, ReadLock, 4, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if err := file.UnlockRange(0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if data := ReadRange(filePath, 0, 5, ofd); string(data) != testData[:5] {
+		t.Fatalf("expected %q, got %q", testData[:5], data)
+	}
+}
+
+func TestRangeLocksRequireRangeLocker(t *testing.T) {
+	filePath := createTestFile(t)
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, WithLocker(FlockLocker{}))
+	defer file.Close()
+	if err := file.LockRange(WriteLock, 0, 5); err == nil || errors.Is(err, ErrLocked) {
+		t.Fatalf("expected byte-range locks to be unsupported, got %v", err)
+	}
+	if _, err := TryReadRange(filePath, 0, 5, WithLocker(FlockLocker{})); err == nil {
+		t.Fatal("expected byte-range locks to be unsupported")
+	}
+}
+
+func TestIsLocked(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	if IsLocked(filePath, ofd) {
+		t.Fatal("expected file not to be locked")
+	}
+	file := OpenFile(filePath, os.O_RDONLY, 0660, ofd)
+	defer file.Close()
+	if !IsLocked(filePath, ofd) {
+		t.Fatal("expected file to be locked")
+	}
+	holder := LockHolder(filePath, ofd)
+	if holder == nil || holder.Type != ReadLock || holder.PID != -1 {
+		t.Fatalf("expected OFD read-lock, got %+v", holder)
+	}
+}
+
+func TestMoveFileRenamesUnlessDestinationIsLocked(t *testing.T) {
+	filePath := createTestFile(t)
+	destinationPath := filepath.Join(filepath.Dir(filePath), "destination.txt")
+	ofd := WithLocker(OFDLocker{})
+	WriteFile(destinationPath, []byte("Hello Gopher"))
+
+	destination := OpenFile(destinationPath, os.O_RDONLY, 0660, ofd)
+	if _, err := TryMoveFile(filePath, destinationPath, ofd); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	expectFileContent(t, filePath, testData)
+	destination.Close()
+
+	var result Result
+	MoveFile(filePath, destinationPath, ofd, WithResult(&result))
+	if result.Move != MoveRename || result.Bytes != int64(len(testData)) {
+		t.Fatalf("expected %d bytes moved using %v, got %+v", len(testData), MoveRename, result)
+	}
+	expectFileContent(t, destinationPath, testData)
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("expected source to be gone, got %v", err)
+	}
+}
+
+func TestMoveFileReplacesTargetOfSymlink(t *testing.T) {
+	filePath := createTestFile(t)
+	dir := filepath.Dir(filePath)
+	linkPath, targetPath := filepath.Join(dir, "link"), filepath.Join(dir, "target")
+	ofd := WithLocker(OFDLocker{})
+	WriteFile(targetPath, []byte("Hello Gopher"))
+	if err := os.Symlink("target", linkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	target := OpenFile(targetPath, os.O_RDONLY, 0660, ofd)
+	if _, err := TryMoveFile(filePath, linkPath, ofd); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	target.Close()
+
+	var result Result
+	MoveFile(filePath, linkPath, ofd, WithResult(&result))
+	if result.Move != MoveRename {
+		t.Fatalf("expected move using %v, got %v", MoveRename, result.Move)
+	}
+	expectFileContent(t, targetPath, testData)
+	if info, err := os.Lstat(linkPath); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected symbolic link to be kept, got %v and %v", info, err)
+	}
+}
+
+func TestXattrsRespectLocks(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+	if err := TrySetXattr(filePath, "user.state", []byte("processing"), ofd); errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("file system does not support extended attributes")
+	}
+
+	file := OpenFile(filePath, os.O_RDONLY, 0660, ofd)
+	if err := TrySetXattr(filePath, "user.state", []byte("done"), ofd); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if value := GetXattr(filePath, "user.state", ofd); string(value) != "processing" {
+		t.Fatalf("expected %q, got %q", "processing", value)
+	}
+	file.Close()
+
+	SetXattr(filePath, "user.state", []byte("done"), ofd)
+	if names := ListXattr(filePath, ofd); len(names) != 1 || names[0] != "user.state" {
+		t.Fatalf("expected [user.state], got %v", names)
+	}
+	RemoveXattr(filePath, "user.state", ofd)
+	if _, err := TryGetXattr(filePath, "user.state", ofd); !errors.Is(err, ErrNoXattr) {
+		t.Fatalf("expected ErrNoXattr, got %v", err)
+	}
+}
+
+func createTestFile(t *testing.T) string {
+	filePath := filepath.Join(t.TempDir(), testSourceFileName)
+	if err := os.WriteFile(filePath, []byte(testData), 0660); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
@@ -0,0 +1,196 @@
+package fio
+
+import (
+	"context"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// LockedFile is a file opened by OpenFile, together with the advisory lock claimed on it.
+// It can be used like an *os.File through the embedded fsi.File. The lock can be released
+// without closing the file and converted between read and write locks.
+//
+// A LockedFile is not safe for concurrent use.
+type LockedFile struct {
+	fsi.File
+	path string
+	typ  LockType
+	o    *options
+}
+
+func newLockedFile(o *options, file fsi.File, filePath string, typ LockType) *LockedFile {
+	return &LockedFile{File: file, path: filePath, typ: typ, o: o}
+}
+
+// Path returns the path the file was opened with.
+func (f *LockedFile) Path() string {
+	return f.path
+}
+
+// LockType returns the type of the lock currently held on the file, or NoLock after calling Unlock().
+func (f *LockedFile) LockType() LockType {
+	return f.typ
+}
+
+// LockHolder is like the function LockHolder, but queries through the descriptor of f instead of
+// opening the file again, so it does not release fcntl(2) locks of the calling process, and does not
+// report the lock held through f. FlockLocker is the exception: see its documentation.
+func (f *LockedFile) LockHolder() (*LockInfo, error) {
+	holder, err := f.o.locker.Query(f.o.fs, f.File, WriteLock)
+	if err != nil {
+		return nil, newOpError("query lock", f.path, "", err)
+	}
+	return holder, nil
+}
+
+// Unlock releases the lock held on the file without closing it.
+func (f *LockedFile) Unlock() error {
+	if f.typ == NoLock {
+		return nil
+	}
+	if err := f.o.locker.Unlock(f.o.fs, f.File); err != nil {
+		return newOpError("unlock", f.path, "", err)
+	}
+	f.typ = NoLock
+	return nil
+}
+
+// UpgradeToWrite converts the lock held on the file into a write lock, or claims a write lock
+// if no lock is held. It does not wait if another process holds a conflicting lock.
+// The file must have been opened for writing.
+//
+// Whether the conversion is atomic depends on the Locker: fcntl(2) locks are converted atomically,
+// while flock(2) locks are briefly released during the conversion.
+//
+// If the conversion fails, the previous lock is kept: locks already converted, such as those of the
+// first lockers of a MultiLocker, are converted back. Should the previous lock have been lost meanwhile,
+// e.g. because another process claimed a briefly released flock(2) lock, all locks are released and
+// LockType() reports NoLock.
+func (f *LockedFile) UpgradeToWrite() error {
+	return f.convertLock(WriteLock)
+}
+
+// DowngradeToRead converts the lock held on the file into a read lock, or claims a read lock
+// if no lock is held. The file must have been opened for reading.
+//
+// Whether the conversion is atomic and what happens if it fails is described at UpgradeToWrite.
+func (f *LockedFile) DowngradeToRead() error {
+	return f.convertLock(ReadLock)
+}
+
+func (f *LockedFile) convertLock(typ LockType) error {
+	if f.typ == typ {
+		return nil
+	}
+	if restored, err := convertLock(f.o.fs, f.File, f.o.locker, f.typ, typ); err != nil {
+		if !restored {
+			f.o.locker.Unlock(f.o.fs, f.File)
+			f.typ = NoLock
+		}
+		return newOpError("lock", f.path, "", newLockError(f.path, typ, err, func() (*LockInfo, error) {
+			return f.o.locker.Query(f.o.fs, f.File, typ)
+		}))
+	}
+	f.typ = typ
+	return nil
+}
+
+// convertLock converts the lock of type from held on file by locker into a lock of type to. If this fails,
+// the locks already converted are converted back, and restored reports whether file is locked with type from again.
+func convertLock(fsys fsi.FileSystem, file fsi.File, locker Locker, from, to LockType) (restored bool, err error) {
+	multi, ok := locker.(MultiLocker)
+	if !ok {
+		if err = setLock(fsys, file, locker, to); err == nil {
+			return true, nil
+		}
+		// Some lockers, like FlockLocker, release the lock held before failing to convert it.
+		return from == NoLock || setLock(fsys, file, locker, from) == nil, err
+	}
+	for i, l := range multi {
+		if restored, err = convertLock(fsys, file, l, from, to); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if setLock(fsys, file, multi[j], from) != nil {
+					restored = false
+				}
+			}
+			return restored, err
+		}
+	}
+	return true, nil
+}
+
+// setLock makes locker hold a lock of type typ on file, or no lock if typ is NoLock.
+func setLock(fsys fsi.FileSystem, file fsi.File, locker Locker, typ LockType) error {
+	if typ == NoLock {
+		return locker.Unlock(fsys, file)
+	}
+	return locker.TryLock(fsys, file, typ)
+}
+
+// LockRange claims a lock of type typ on length bytes of the file starting at offset start,
+// without waiting if another process holds a conflicting lock. A length of 0 extends the range
+// to the end of the file, however large it grows. The Locker must implement RangeLocker.
+//
+// Range locks are independent of LockType(), which only reflects the lock on the whole file.
+// Note however that with fcntl(2) locks, locking or unlocking a range converts that part of a
+// whole-file lock held by the same owner. To hold nothing but range locks, call Unlock() first.
+func (f *LockedFile) LockRange(typ LockType, start, length int64) error {
+	locker, err := rangeLocker(f.o.locker)
+	if err != nil {
+		return newOpError("lock range", f.path, "", err)
+	}
+	if err = locker.TryLockRange(f.o.fs, f.File, typ, start, length); err != nil {
+		return newOpError("lock range", f.path, "", f.newRangeLockError(locker, typ, start, length, err))
+	}
+	return nil
+}
+
+// LockRangeContext is like LockRange, but waits for conflicting locks to be released until ctx is done.
+func (f *LockedFile) LockRangeContext(ctx context.Context, typ LockType, start, length int64) error {
+	locker, err := rangeLocker(f.o.locker)
+	if err != nil {
+		return newOpError("lock range", f.path, "", err)
+	}
+	if err = locker.LockRange(ctx, f.o.fs, f.File, typ, start, length); err != nil {
+		return newOpError("lock range", f.path, "", f.newRangeLockError(locker, typ, start, length, err))
+	}
+	return nil
+}
+
+func (f *LockedFile) newRangeLockError(locker RangeLocker, typ LockType, start, length int64, err error) *LockError {
+	return newLockError(f.path, typ, err, func() (*LockInfo, error) {
+		return locker.QueryRange(f.o.fs, f.File, typ, start, length)
+	})
+}
+
+// UnlockRange releases the locks held on length bytes of the file starting at offset start.
+// The Locker must implement RangeLocker.
+func (f *LockedFile) UnlockRange(start, length int64) error {
+	locker, err := rangeLocker(f.o.locker)
+	if err == nil {
+		err = locker.UnlockRange(f.o.fs, f.File, start, length)
+	}
+	if err != nil {
+		return newOpError("unlock range", f.path, "", err)
+	}
+	return nil
+}
+
+// Close releases the lock held on the file, closes it and logs on success.
+func (f *LockedFile) Close() error {
+	var unlockErr error
+	if f.typ != NoLock && !isReleasedOnClose(f.o.locker) {
+		unlockErr = f.o.locker.Unlock(f.o.fs, f.File)
+	}
+	f.typ = NoLock
+	if err := f.File.Close(); err != nil {
+		return newOpError("close", f.path, "", err)
+	}
+	if unlockErr != nil {
+		return newOpError("unlock", f.path, "", unlockErr)
+	}
+	if log := f.o.log; log != nil {
+		log.Printf("Closed '%s'.", f.path)
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+package fio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// Locker claims and releases advisory locks on files opened by package fio.
+// Choose the Locker matching the locking convention of the software you share files with.
+//
+// All file system access must be made through fsys.
+type Locker interface {
+	// Lock claims a lock of type typ on file, waiting for conflicting locks
+	// to be released until ctx is done.
+	Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error
+	// TryLock claims a lock of type typ on file without waiting. If a conflicting
+	// lock is held, the returned error must match ErrLocked or syscall.EAGAIN.
+	TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error
+	// Unlock releases the lock claimed on file.
+	Unlock(fsys fsi.FileSystem, file fsi.File) error
+	// Query returns a lock which conflicts with a lock of type typ on file, or nil if there is none.
+	Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error)
+}
+
+// RangeLocker is implemented by lockers which can lock byte ranges of a file instead of the whole file.
+// A range is given by its offset start and its length. A length of 0 extends the range to the end of
+// the file, however large it grows.
+type RangeLocker interface {
+	Locker
+	// LockRange claims a lock of type typ on the given range of file, waiting for conflicting locks
+	// to be released until ctx is done.
+	LockRange(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error
+	// TryLockRange claims a lock of type typ on the given range of file without waiting.
+	TryLockRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error
+	// UnlockRange releases the locks claimed on the given range of file.
+	UnlockRange(fsys fsi.FileSystem, file fsi.File, start, length int64) error
+	// QueryRange returns a lock which conflicts with a lock of type typ on the given range of file, or nil if there is none.
+	QueryRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) (*LockInfo, error)
+}
+
+func rangeLocker(locker Locker) (RangeLocker, error) {
+	if r, ok := locker.(RangeLocker); ok {
+		return r, nil
+	}
+	return nil, fmt.Errorf("%T does not support byte-range locks", locker)
+}
+
+// newLockError creates a LockError for a failure to claim a lock of type typ on the file at filePath.
+// If the failure was caused by a conflicting lock, query is used to find out which process holds it.
+func newLockError(filePath string, typ LockType, err error, query func() (*LockInfo, error)) *LockError {
+	lockErr := &LockError{Path: filePath, Type: typ, Err: err}
+	if isLockConflict(err) {
+		if holder, queryErr := query(); queryErr == nil && holder != nil {
+			lockErr.PID = holder.PID
+		}
+	}
+	return lockErr
+}
+
+// DefaultLocker is the Locker used by all functions of package fio unless overridden with WithLocker().
+var DefaultLocker Locker = FcntlLocker{}
+
+// closeReleaser is implemented by lockers whose locks are released by closing the locked
+// file, which allows package fio to skip calling Unlock() before closing a file.
+type closeReleaser interface {
+	releasedOnClose() bool
+}
+
+func isReleasedOnClose(locker Locker) bool {
+	r, ok := locker.(closeReleaser)
+	return ok && r.releasedOnClose()
+}
+
+// FcntlLocker claims classic POSIX record locks using fcntl(2) with F_SETLK.
+// These locks are owned by the process: all descriptors of a process share them,
+// so they do not exclude goroutines of the same process from one another, and
+// closing any descriptor of the file releases all of the process' locks on it.
+//
+// This is the convention followed by most FTP servers.
+type FcntlLocker struct{}
+
+func (l FcntlLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return l.LockRange(ctx, fsys, file, typ, 0, 0)
+}
+
+func (l FcntlLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return l.TryLockRange(fsys, file, typ, 0, 0)
+}
+
+func (l FcntlLocker) Unlock(fsys fsi.FileSystem, file fsi.File) error {
+	return l.UnlockRange(fsys, file, 0, 0)
+}
+
+func (l FcntlLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	return l.QueryRange(fsys, file, typ, 0, 0)
+}
+
+func (FcntlLocker) LockRange(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return lockFcntl(ctx, fsys, file, syscall.F_SETLK, typ, start, length)
+}
+
+func (FcntlLocker) TryLockRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return fcntlConflict(fsys.FcntlFlock(file.Fd(), syscall.F_SETLK, lockWithRange(int16(typ), start, length)))
+}
+
+func (FcntlLocker) UnlockRange(fsys fsi.FileSystem, file fsi.File, start, length int64) error {
+	return fsys.FcntlFlock(file.Fd(), syscall.F_SETLK, lockWithRange(syscall.F_UNLCK, start, length))
+}
+
+func (FcntlLocker) QueryRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) (*LockInfo, error) {
+	return queryFcntl(fsys, file, syscall.F_GETLK, typ, start, length)
+}
+
+func (FcntlLocker) releasedOnClose() bool {
+	return true
+}
+
+// OFDLocker claims open file description locks using fcntl(2) with F_OFD_SETLK.
+// These locks are owned by the descriptor opened by fio, so two descriptors of
+// the same process exclude each other and closing unrelated descriptors of
+// the same file does not release the lock. They conflict with FcntlLocker locks
+// held by other processes. Requires Linux 3.15 or later.
+type OFDLocker struct{}
+
+// Open file description lock commands for fcntl(2). The syscall package does not define them.
+const (
+	fOFDGetlk = 36
+	fOFDSetlk = 37
+)
+
+func (l OFDLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return l.LockRange(ctx, fsys, file, typ, 0, 0)
+}
+
+func (l OFDLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return l.TryLockRange(fsys, file, typ, 0, 0)
+}
+
+func (l OFDLocker) Unlock(fsys fsi.FileSystem, file fsi.File) error {
+	return l.UnlockRange(fsys, file, 0, 0)
+}
+
+func (l OFDLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	return l.QueryRange(fsys, file, typ, 0, 0)
+}
+
+func (OFDLocker) LockRange(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return lockFcntl(ctx, fsys, file, fOFDSetlk, typ, start, length)
+}
+
+func (OFDLocker) TryLockRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return fcntlConflict(fsys.FcntlFlock(file.Fd(), fOFDSetlk, lockWithRange(int16(typ), start, length)))
+}
+
+func (OFDLocker) UnlockRange(fsys fsi.FileSystem, file fsi.File, start, length int64) error {
+	return fsys.FcntlFlock(file.Fd(), fOFDSetlk, lockWithRange(syscall.F_UNLCK, start, length))
+}
+
+func (OFDLocker) QueryRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) (*LockInfo, error) {
+	return queryFcntl(fsys, file, fOFDGetlk, typ, start, length)
+}
+
+func (OFDLocker) releasedOnClose() bool {
+	return true
+}
+
+func lockFcntl(ctx context.Context, fsys fsi.FileSystem, file fsi.File, cmd int, typ LockType, start, length int64) error {
+	return waitForLock(ctx, func() error {
+		return fcntlConflict(fsys.FcntlFlock(file.Fd(), cmd, lockWithRange(int16(typ), start, length)))
+	})
+}
+
+func queryFcntl(fsys fsi.FileSystem, file fsi.File, cmd int, typ LockType, start, length int64) (*LockInfo, error) {
+	lk := lockWithRange(int16(typ), start, length)
+	if err := fsys.FcntlFlock(file.Fd(), cmd, lk); err != nil {
+		return nil, err
+	}
+	if LockType(lk.Type) == NoLock {
+		return nil, nil
+	}
+	return &LockInfo{Type: LockType(lk.Type), Start: lk.Start, Len: lk.Len, PID: int(lk.Pid)}, nil
+}
+
+// FlockLocker claims BSD locks using flock(2), as used by flock(1) and some mail and backup tools.
+// Like OFDLocker locks, these are owned by the descriptor opened by fio. On Linux they do not
+// interact with locks claimed using fcntl(2) at all.
+//
+// Query cannot tell which process holds a lock. It probes through another descriptor of the file,
+// so it also reports a lock held through file itself, and closing that descriptor releases the
+// fcntl(2) locks of the calling process, such as those of FcntlLocker in a MultiLocker.
+type FlockLocker struct{}
+
+func (l FlockLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return waitForLock(ctx, func() error { return l.TryLock(fsys, file, typ) })
+}
+
+func (FlockLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return fsys.Flock(file.Fd(), flockHow(typ)|syscall.LOCK_NB)
+}
+
+func (FlockLocker) Unlock(fsys fsi.FileSystem, file fsi.File) error {
+	return fsys.Flock(file.Fd(), syscall.LOCK_UN)
+}
+
+func (FlockLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	probe, err := fsys.OpenFile(file.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer probe.Close()
+	err = fsys.Flock(probe.Fd(), flockHow(typ)|syscall.LOCK_NB)
+	if err == nil {
+		return nil, nil
+	}
+	if !isLockConflict(err) {
+		return nil, err
+	}
+	if typ == ReadLock {
+		return &LockInfo{Type: WriteLock}, nil
+	}
+	if fsys.Flock(probe.Fd(), syscall.LOCK_SH|syscall.LOCK_NB) == nil {
+		return &LockInfo{Type: ReadLock}, nil
+	}
+	return &LockInfo{Type: WriteLock}, nil
+}
+
+func (FlockLocker) releasedOnClose() bool {
+	return true
+}
+
+func flockHow(typ LockType) int {
+	if typ == WriteLock {
+		return syscall.LOCK_EX
+	}
+	return syscall.LOCK_SH
+}
+
+// LockFileLocker locks a file by creating a lock file next to it, whose name is the
+// name of the locked file with Suffix appended, or ".lock" if Suffix is empty.
+// The lock file contains the ID of the process which created it.
+//
+// Lock files do not distinguish between read and write locks: every lock is exclusive.
+// Unlike the other lockers, lock files are not removed by closing the locked file,
+// and stale lock files of crashed processes must be removed manually.
+//
+// Since every lock is exclusive, converting the lock of a file which already holds its lock file does nothing.
+type LockFileLocker struct {
+	Suffix string
+}
+
+// lockFileHolders maps the lock files created by LockFileLocker in this process to the files they lock.
+var lockFileHolders = struct {
+	sync.Mutex
+	files map[lockFileKey]fsi.File
+}{files: map[lockFileKey]fsi.File{}}
+
+type lockFileKey struct {
+	fsys fsi.FileSystem
+	path string
+}
+
+func holdsLockFile(key lockFileKey, file fsi.File) bool {
+	lockFileHolders.Lock()
+	defer lockFileHolders.Unlock()
+	return lockFileHolders.files[key] == file
+}
+
+func setLockFileHolder(key lockFileKey, file fsi.File) {
+	lockFileHolders.Lock()
+	defer lockFileHolders.Unlock()
+	if file == nil {
+		delete(lockFileHolders.files, key)
+	} else {
+		lockFileHolders.files[key] = file
+	}
+}
+
+func (l LockFileLocker) lockFilePath(file fsi.File) string {
+	if l.Suffix == "" {
+		return file.Name() + ".lock"
+	}
+	return file.Name() + l.Suffix
+}
+
+func (l LockFileLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return waitForLock(ctx, func() error { return l.TryLock(fsys, file, typ) })
+}
+
+func (l LockFileLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	lockFilePath := l.lockFilePath(file)
+	key := lockFileKey{fsys: fsys, path: lockFilePath}
+	lockFile, err := fsys.OpenFile(lockFilePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			if holdsLockFile(key, file) {
+				return nil
+			}
+			return fmt.Errorf("lock file '%s' exists: %w", lockFilePath, ErrLocked)
+		}
+		return err
+	}
+	_, err = lockFile.Write([]byte(strconv.Itoa(os.Getpid()) + "\n"))
+	if closeErr := lockFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fsys.Remove(lockFilePath)
+		return err
+	}
+	setLockFileHolder(key, file)
+	return nil
+}
+
+func (l LockFileLocker) Unlock(fsys fsi.FileSystem, file fsi.File) error {
+	lockFilePath := l.lockFilePath(file)
+	key := lockFileKey{fsys: fsys, path: lockFilePath}
+	if holdsLockFile(key, file) {
+		setLockFileHolder(key, nil)
+	}
+	return fsys.Remove(lockFilePath)
+}
+
+func (l LockFileLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	lockFilePath := l.lockFilePath(file)
+	if holdsLockFile(lockFileKey{fsys: fsys, path: lockFilePath}, file) {
+		return nil, nil
+	}
+	lockFile, err := fsys.OpenFile(lockFilePath, os.O_RDONLY, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer lockFile.Close()
+	content, err := ioutil.ReadAll(lockFile)
+	if err != nil {
+		return nil, err
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return &LockInfo{Type: WriteLock, PID: pid}, nil
+}
+
+// NoLocker does not lock files at all.
+type NoLocker struct{}
+
+func (NoLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return nil
+}
+
+func (NoLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return nil
+}
+
+func (NoLocker) Unlock(fsys fsi.FileSystem, file fsi.File) error {
+	return nil
+}
+
+func (NoLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	return nil, nil
+}
+
+func (NoLocker) LockRange(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return nil
+}
+
+func (NoLocker) TryLockRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) error {
+	return nil
+}
+
+func (NoLocker) UnlockRange(fsys fsi.FileSystem, file fsi.File, start, length int64) error {
+	return nil
+}
+
+func (NoLocker) QueryRange(fsys fsi.FileSystem, file fsi.File, typ LockType, start, length int64) (*LockInfo, error) {
+	return nil, nil
+}
+
+func (NoLocker) releasedOnClose() bool {
+	return true
+}
+
+// MultiLocker claims the locks of all of its lockers, in order. If any of them cannot
+// be claimed, the ones already claimed are released again. This allows cooperating with
+// software using different locking conventions at once, e.g. MultiLocker{FcntlLocker{}, FlockLocker{}}.
+type MultiLocker []Locker
+
+func (l MultiLocker) Lock(ctx context.Context, fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	return waitForLock(ctx, func() error { return l.TryLock(fsys, file, typ) })
+}
+
+func (l MultiLocker) TryLock(fsys fsi.FileSystem, file fsi.File, typ LockType) error {
+	for i, locker := range l {
+		if err := locker.TryLock(fsys, file, typ); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				l[j].Unlock(fsys, file)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (l MultiLocker) Unlock(fsys fsi.FileSystem, file fsi.File) (err error) {
+	for i := len(l) - 1; i >= 0; i-- {
+		if unlockErr := l[i].Unlock(fsys, file); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}
+	return err
+}
+
+func (l MultiLocker) Query(fsys fsi.FileSystem, file fsi.File, typ LockType) (*LockInfo, error) {
+	for _, locker := range l {
+		info, err := locker.Query(fsys, file, typ)
+		if err != nil || info != nil {
+			return info, err
+		}
+	}
+	return nil, nil
+}
+
+func (l MultiLocker) releasedOnClose() bool {
+	for _, locker := range l {
+		if !isReleasedOnClose(locker) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,414 @@
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// seekData and seekHole are the lseek(2) whence values which find the next data segment and hole of a file.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// File is a file opened by a Process. Like a descriptor opened with open(2), it has its own offset
+// and owns the open file description and flock(2) locks claimed through it. It implements fsi.File.
+type File struct {
+	proc   *Process
+	node   *node
+	fd     uintptr
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+var _ fsi.File = (*File)(nil)
+
+func (f *File) readable() bool {
+	return f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func (f *File) writable() bool {
+	return f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) != os.O_RDONLY
+}
+
+// check returns the error for accessing the data of f, which must be open, for reading or writing.
+func (f *File) check(write bool) error {
+	switch {
+	case f.closed:
+		return os.ErrClosed
+	case write && !f.writable(), !write && !f.readable():
+		return syscall.EBADF
+	case f.node.mode.IsDir():
+		return syscall.EISDIR
+	}
+	return nil
+}
+
+func (f *File) Read(b []byte) (int, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if err := f.check(false); err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	n := f.node.readAt(b, f.offset)
+	f.offset += int64(n)
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	err := f.check(false)
+	if err == nil && off < 0 {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	n := f.node.readAt(b, off)
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Write(b []byte) (int, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if err := f.check(true); err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	f.node.writeAt(b, f.offset)
+	f.offset += int64(len(b))
+	return len(b), nil
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errors.New("os: invalid use of WriteAt on file opened with O_APPEND")
+	}
+	err := f.check(true)
+	if err == nil && off < 0 {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	f.node.writeAt(b, off)
+	return len(b), nil
+}
+
+// Seek sets the offset of f like lseek(2), including SEEK_DATA and SEEK_HOLE.
+// Since files are never sparse, their only hole is the one at their end.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	size := int64(len(f.node.data))
+	var err error
+	switch {
+	case f.closed:
+		err = os.ErrClosed
+	case whence == io.SeekStart:
+	case whence == io.SeekCurrent:
+		offset += f.offset
+	case whence == io.SeekEnd:
+		offset += size
+	case whence == seekData || whence == seekHole:
+		if offset < 0 {
+			err = syscall.EINVAL
+		} else if offset >= size {
+			err = syscall.ENXIO
+		} else if whence == seekHole {
+			offset = size
+		}
+	default:
+		err = syscall.EINVAL
+	}
+	if err == nil && offset < 0 {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: err}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes f, releasing the open file description and flock(2) locks claimed through it,
+// as well as all fcntl(2) record locks its process holds on the file.
+func (f *File) Close() error {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closeLocked()
+	return nil
+}
+
+func (f *File) closeLocked() {
+	f.closed = true
+	delete(f.proc.files, f.fd)
+	f.node.releaseLocks(f)
+	f.proc.fsys.unlocked.Broadcast()
+}
+
+// Fd returns the descriptor of f, which is only valid for the process which opened it, or ^uintptr(0) if f is closed.
+func (f *File) Fd() uintptr {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if f.closed {
+		return ^uintptr(0)
+	}
+	return f.fd
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
+	return newFileInfo(path.Base(f.name), f.node), nil
+}
+
+func (f *File) Truncate(size int64) error {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	err := f.check(true)
+	if errors.Is(err, syscall.EBADF) || (err == nil && size < 0) {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+	f.node.resize(size)
+	f.node.mtime = time.Now()
+	return nil
+}
+
+func (f *File) Sync() error {
+	f.proc.fsys.mu.Lock()
+	defer f.proc.fsys.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (n *node) readAt(b []byte, off int64) int {
+	if off >= int64(len(n.data)) {
+		return 0
+	}
+	return copy(b, n.data[off:])
+}
+
+func (n *node) writeAt(b []byte, off int64) {
+	if end := off + int64(len(b)); end > int64(len(n.data)) {
+		n.resize(end)
+	}
+	copy(n.data[off:], b)
+	n.mtime = time.Now()
+}
+
+func (n *node) resize(size int64) {
+	switch {
+	case size <= int64(len(n.data)):
+		n.data = n.data[:size]
+	case size <= int64(cap(n.data)):
+		// The capacity beyond the length may hold data of a previous truncation.
+		tail := n.data[len(n.data):size]
+		for i := range tail {
+			tail[i] = 0
+		}
+		n.data = n.data[:size]
+	default:
+		data := make([]byte, size, size+size/4)
+		copy(data, n.data)
+		n.data = data
+	}
+}
+
+// file returns the file opened by p with the descriptor fd.
+func (p *Process) file(fd uintptr) (*File, error) {
+	if file, ok := p.files[fd]; ok {
+		return file, nil
+	}
+	return nil, syscall.EBADF
+}
+
+func (p *Process) Fdatasync(fd uintptr) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	_, err := p.file(fd)
+	return err
+}
+
+// IoctlFileClone replaces the content of the file opened as dstFd with that of the file opened as srcFd,
+// like the FICLONE ioctl on a copy-on-write file system.
+func (p *Process) IoctlFileClone(dstFd, srcFd uintptr) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	dst, src, err := p.transferFiles(dstFd, srcFd)
+	if err != nil {
+		return err
+	}
+	if dst.node == src.node {
+		return syscall.EINVAL
+	}
+	dst.node.data = append([]byte(nil), src.node.data...)
+	dst.node.mtime = time.Now()
+	return nil
+}
+
+func (p *Process) CopyFileRange(srcFd uintptr, srcOff *int64, dstFd uintptr, dstOff *int64, length int, flags int) (int, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	dst, src, err := p.transferFiles(dstFd, srcFd)
+	if err != nil {
+		return 0, err
+	}
+	if flags != 0 || length < 0 {
+		return 0, syscall.EINVAL
+	}
+	if dst.flag&os.O_APPEND != 0 {
+		return 0, syscall.EBADF
+	}
+	return transfer(src, srcOff, dst, dstOff, length), nil
+}
+
+func (p *Process) Sendfile(dstFd, srcFd uintptr, offset *int64, count int) (int, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	dst, src, err := p.transferFiles(dstFd, srcFd)
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, syscall.EINVAL
+	}
+	if dst.flag&os.O_APPEND != 0 {
+		dst.offset = int64(len(dst.node.data))
+	}
+	return transfer(src, offset, dst, nil, count), nil
+}
+
+// transferFiles returns the files opened by p with the descriptors dstFd and srcFd,
+// which must be regular files opened for writing and reading respectively.
+func (p *Process) transferFiles(dstFd, srcFd uintptr) (dst, src *File, err error) {
+	if dst, err = p.file(dstFd); err != nil {
+		return nil, nil, err
+	}
+	if src, err = p.file(srcFd); err != nil {
+		return nil, nil, err
+	}
+	if !dst.writable() || !src.readable() {
+		return nil, nil, syscall.EBADF
+	}
+	if !dst.node.mode.IsRegular() || !src.node.mode.IsRegular() {
+		return nil, nil, syscall.EINVAL
+	}
+	return dst, src, nil
+}
+
+// transfer copies up to length bytes from src to dst, starting at *srcOff and *dstOff, which are
+// advanced by the amount copied, or at the offsets of the files if these pointers are nil.
+func transfer(src *File, srcOff *int64, dst *File, dstOff *int64, length int) int {
+	if srcOff == nil {
+		srcOff = &src.offset
+	}
+	if dstOff == nil {
+		dstOff = &dst.offset
+	}
+	if *srcOff >= int64(len(src.node.data)) {
+		return 0
+	}
+	data := src.node.data[*srcOff:]
+	if len(data) > length {
+		data = data[:length]
+	}
+	dst.node.writeAt(append([]byte(nil), data...), *dstOff)
+	*srcOff += int64(len(data))
+	*dstOff += int64(len(data))
+	return len(data)
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return &fileInfo{name: name, size: size, mode: n.mode, modTime: n.mtime}
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	return fi.mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+type dirEntry struct {
+	info *fileInfo
+}
+
+func (e dirEntry) Name() string {
+	return e.info.name
+}
+
+func (e dirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e dirEntry) Type() fs.FileMode {
+	return e.info.mode.Type()
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	return e.info, nil
+}
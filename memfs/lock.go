@@ -0,0 +1,213 @@
+package memfs
+
+import (
+	"io"
+	"math"
+	"syscall"
+)
+
+// Open file description lock commands for fcntl(2). The syscall package does not define them.
+const (
+	fOFDGetlk  = 36
+	fOFDSetlk  = 37
+	fOFDSetlkw = 38
+)
+
+// maxOffset is the end of record locks which extend to the end of the file, however far it grows.
+const maxOffset = math.MaxInt64
+
+// lockRecord is a fcntl(2) lock on the bytes [start, end) of a file.
+type lockRecord struct {
+	// owner is the *Process holding a record lock or the *File holding an open file description lock.
+	owner interface{}
+	// pid is reported to processes querying the lock; -1 for open file description locks.
+	pid        int32
+	typ        int16
+	start, end int64
+}
+
+// FcntlFlock claims, releases or queries a lock like fcntl(2) with F_SETLK, F_SETLKW, F_GETLK and their
+// open file description counterparts. F_SETLKW and F_OFD_SETLKW wait until the lock can be claimed;
+// deadlocks between processes are not detected.
+func (p *Process) FcntlFlock(fd uintptr, cmd int, lk *syscall.Flock_t) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	file, err := p.file(fd)
+	if err != nil {
+		return err
+	}
+	var owner interface{} = p
+	pid := int32(p.pid)
+	switch cmd {
+	case syscall.F_GETLK, syscall.F_SETLK, syscall.F_SETLKW:
+	case fOFDGetlk, fOFDSetlk, fOFDSetlkw:
+		if lk.Pid != 0 {
+			return syscall.EINVAL
+		}
+		owner, pid = file, -1
+	default:
+		return syscall.EINVAL
+	}
+	if lk.Type != syscall.F_RDLCK && lk.Type != syscall.F_WRLCK && lk.Type != syscall.F_UNLCK {
+		return syscall.EINVAL
+	}
+	start, end, err := file.lockRange(lk)
+	if err != nil {
+		return err
+	}
+	n := file.node
+	if cmd == syscall.F_GETLK || cmd == fOFDGetlk {
+		if lk.Type == syscall.F_UNLCK {
+			return syscall.EINVAL
+		}
+		held := n.conflictingLock(owner, lk.Type, start, end)
+		if held == nil {
+			lk.Type = syscall.F_UNLCK
+			return nil
+		}
+		lk.Type, lk.Whence, lk.Start, lk.Len, lk.Pid = held.typ, io.SeekStart, held.start, held.end-held.start, held.pid
+		if held.end == maxOffset {
+			lk.Len = 0
+		}
+		return nil
+	}
+	if (lk.Type == syscall.F_RDLCK && !file.readable()) || (lk.Type == syscall.F_WRLCK && !file.writable()) {
+		return syscall.EBADF
+	}
+	for lk.Type != syscall.F_UNLCK && n.conflictingLock(owner, lk.Type, start, end) != nil {
+		if cmd == syscall.F_SETLK || cmd == fOFDSetlk {
+			return syscall.EAGAIN
+		}
+		p.fsys.unlocked.Wait()
+		if file.closed {
+			return syscall.EBADF
+		}
+	}
+	n.setLock(&lockRecord{owner: owner, pid: pid, typ: lk.Type, start: start, end: end})
+	p.fsys.unlocked.Broadcast()
+	return nil
+}
+
+// lockRange returns the range of bytes [start, end) of f described by lk.
+func (f *File) lockRange(lk *syscall.Flock_t) (start, end int64, err error) {
+	switch lk.Whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		start = f.offset
+	case io.SeekEnd:
+		start = int64(len(f.node.data))
+	default:
+		return 0, 0, syscall.EINVAL
+	}
+	start += lk.Start
+	end = maxOffset
+	if lk.Len > 0 {
+		end = start + lk.Len
+	} else if lk.Len < 0 {
+		start, end = start+lk.Len, start
+	}
+	if start < 0 {
+		return 0, 0, syscall.EINVAL
+	}
+	return start, end, nil
+}
+
+// conflictingLock returns a lock on n held by an owner other than owner which prevents owner
+// from claiming a lock of type typ on the bytes [start, end), or nil if there is none.
+func (n *node) conflictingLock(owner interface{}, typ int16, start, end int64) *lockRecord {
+	for _, held := range n.locks {
+		if held.owner != owner && held.start < end && start < held.end && (typ == syscall.F_WRLCK || held.typ == syscall.F_WRLCK) {
+			return held
+		}
+	}
+	return nil
+}
+
+// setLock replaces the locks of the owner of lock on the range of lock with lock, or removes them if it is an unlock.
+func (n *node) setLock(lock *lockRecord) {
+	var locks []*lockRecord
+	for _, held := range n.locks {
+		if held.owner != lock.owner || held.end <= lock.start || lock.end <= held.start {
+			locks = append(locks, held)
+			continue
+		}
+		if held.start < lock.start {
+			before := *held
+			before.end = lock.start
+			locks = append(locks, &before)
+		}
+		if held.end > lock.end {
+			after := *held
+			after.start = lock.end
+			locks = append(locks, &after)
+		}
+	}
+	if lock.typ != syscall.F_UNLCK {
+		locks = append(locks, lock)
+	}
+	n.locks = locks
+}
+
+// releaseLocks removes the locks released by closing f: its open file description and flock(2)
+// locks, as well as all record locks the process of f holds on n.
+func (n *node) releaseLocks(f *File) {
+	var locks []*lockRecord
+	for _, held := range n.locks {
+		if held.owner != f && held.owner != f.proc {
+			locks = append(locks, held)
+		}
+	}
+	n.locks = locks
+	delete(n.flocks, f)
+}
+
+// Flock claims or releases a lock like flock(2). Without LOCK_NB, it waits until the lock can be claimed.
+// Like on Linux, converting a lock first releases the lock held, so a failed conversion leaves f unlocked.
+func (p *Process) Flock(fd uintptr, how int) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	file, err := p.file(fd)
+	if err != nil {
+		return err
+	}
+	n := file.node
+	nonBlocking := how&syscall.LOCK_NB != 0
+	how &^= syscall.LOCK_NB
+	if how != syscall.LOCK_SH && how != syscall.LOCK_EX && how != syscall.LOCK_UN {
+		return syscall.EINVAL
+	}
+	if held, ok := n.flocks[file]; ok {
+		if held == how {
+			return nil
+		}
+		delete(n.flocks, file)
+		p.fsys.unlocked.Broadcast()
+	}
+	if how == syscall.LOCK_UN {
+		return nil
+	}
+	for n.flockConflicts(file, how) {
+		if nonBlocking {
+			return syscall.EWOULDBLOCK
+		}
+		p.fsys.unlocked.Wait()
+		if file.closed {
+			return syscall.EBADF
+		}
+	}
+	if n.flocks == nil {
+		n.flocks = map[*File]int{}
+	}
+	n.flocks[file] = how
+	return nil
+}
+
+// flockConflicts reports whether a flock(2) lock held on n through a file other than f prevents claiming a lock of kind how.
+func (n *node) flockConflicts(f *File, how int) bool {
+	for holder, held := range n.flocks {
+		if holder != f && (how == syscall.LOCK_EX || held == syscall.LOCK_EX) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,539 @@
+// Package memfs provides an in-memory implementation of fsi.FileSystem for tests.
+//
+// An FS holds a tree of directories, regular files and symbolic links. It is accessed through
+// simulated processes created with NewProcess, each of which implements fsi.FileSystem and can
+// be passed to fio.WithFileSystem. Processes have their own user, umask and file descriptors,
+// and the advisory locks they claim conflict with each other like those of real processes:
+//
+//   - fcntl(2) record locks (F_SETLK, F_SETLKW, F_GETLK) are owned by the process, and closing any
+//     descriptor of a file releases all record locks the process holds on it.
+//   - Open file description locks (F_OFD_SETLK, F_OFD_SETLKW, F_OFD_GETLK) are owned by the descriptor.
+//   - flock(2) locks are owned by the descriptor and do not interact with fcntl(2) locks.
+//
+// Permissions are checked against the user and group of the process, where user 0 may access everything.
+// Every process has the root directory as its working directory, so relative paths are resolved from "/".
+// Locks are advisory only, file data is never sparse and the Sys() method of file infos returns nil.
+package memfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// maxSymlinks is the number of symbolic links followed while resolving a path before failing with ELOOP.
+const maxSymlinks = 40
+
+// FS is an in-memory file system shared by simulated processes. It is safe for concurrent use.
+type FS struct {
+	mu sync.Mutex
+	// unlocked is signaled whenever a lock is released, waking processes which wait for one.
+	unlocked *sync.Cond
+	root     *node
+	nextIno  uint64
+	nextPid  int
+	nextFd   uintptr
+}
+
+// node is a directory, regular file or symbolic link.
+type node struct {
+	ino      uint64
+	mode     fs.FileMode
+	uid, gid int
+	nlink    int
+	data     []byte
+	entries  map[string]*node
+	target   string
+	atime    time.Time
+	mtime    time.Time
+	xattrs   map[string][]byte
+	locks    []*lockRecord
+	flocks   map[*File]int
+}
+
+// New returns an FS containing only the root directory, which is owned by user 0 and writable by everyone.
+func New() *FS {
+	fsys := &FS{nextIno: 1, nextPid: 100, nextFd: 3}
+	fsys.unlocked = sync.NewCond(&fsys.mu)
+	fsys.root = fsys.newNode(fs.ModeDir|0777, 0, 0)
+	fsys.root.nlink = 2
+	return fsys
+}
+
+func (fsys *FS) newNode(mode fs.FileMode, uid, gid int) *node {
+	now := time.Now()
+	n := &node{ino: fsys.nextIno, mode: mode, uid: uid, gid: gid, nlink: 1, atime: now, mtime: now}
+	fsys.nextIno++
+	if mode.IsDir() {
+		n.entries = map[string]*node{}
+		n.nlink = 2
+	}
+	return n
+}
+
+// Process is a simulated process accessing an FS. It implements fsi.FileSystem.
+type Process struct {
+	fsys     *FS
+	pid      int
+	uid, gid int
+	umask    fs.FileMode
+	files    map[uintptr]*File
+}
+
+var _ fsi.FileSystem = (*Process)(nil)
+
+// NewProcess returns a new process of user 1000 and group 1000 with umask 022.
+func (fsys *FS) NewProcess() *Process {
+	return fsys.NewProcessAs(1000, 1000)
+}
+
+// NewProcessAs returns a new process of user uid and group gid with umask 022.
+// Processes of user 0 are not subject to permission checks.
+func (fsys *FS) NewProcessAs(uid, gid int) *Process {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	p := &Process{fsys: fsys, pid: fsys.nextPid, uid: uid, gid: gid, umask: 022, files: map[uintptr]*File{}}
+	fsys.nextPid++
+	return p
+}
+
+// Pid returns the ID of p, as reported to other processes querying its fcntl(2) locks.
+func (p *Process) Pid() int {
+	return p.pid
+}
+
+// Umask sets the file mode creation mask of p to mask and returns the previous mask.
+func (p *Process) Umask(mask fs.FileMode) fs.FileMode {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	old := p.umask
+	p.umask = mask & fs.ModePerm
+	return old
+}
+
+// Exit closes all files still opened by p, releasing its locks, like the termination of a real process would.
+func (p *Process) Exit() {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	for _, file := range p.files {
+		file.closeLocked()
+	}
+}
+
+// permitted reports whether p may access n as requested by want, a combination of the
+// permission bits for others: 4 for reading, 2 for writing and 1 for executing or searching.
+func (p *Process) permitted(n *node, want fs.FileMode) bool {
+	if p.uid == 0 {
+		return want&1 == 0 || n.mode.IsDir() || n.mode&0111 != 0
+	}
+	perm := n.mode.Perm()
+	switch {
+	case p.uid == n.uid:
+		perm >>= 6
+	case p.gid == n.gid:
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+func (p *Process) isOwner(n *node) bool {
+	return p.uid == 0 || p.uid == n.uid
+}
+
+// lookup is the result of resolving a path: the directory containing it, the name of its
+// last element in that directory and the node it refers to, which is nil if it does not exist.
+type lookup struct {
+	dir  *node
+	name string
+	node *node
+	path string
+}
+
+// resolve looks up name, following symbolic links in all elements but the last, and in the last element if follow is set.
+func (p *Process) resolve(name string, follow bool) (*lookup, error) {
+	if name == "" {
+		return nil, syscall.ENOENT
+	}
+	elems := splitPath(name)
+	for hops := 0; ; hops++ {
+		if hops > maxSymlinks {
+			return nil, syscall.ELOOP
+		}
+		res, rest, err := p.walk(elems, follow)
+		if err != nil || rest == nil {
+			return res, err
+		}
+		elems = rest
+	}
+}
+
+// walk resolves elems from the root directory. If it encounters a symbolic link to follow,
+// it returns the elements of the path with the link replaced by its target instead.
+func (p *Process) walk(elems []string, follow bool) (*lookup, []string, error) {
+	dir, dirPath := p.fsys.root, "/"
+	if len(elems) == 0 {
+		return &lookup{dir: dir, node: dir, path: dirPath}, nil, nil
+	}
+	for i, elem := range elems {
+		if !dir.mode.IsDir() {
+			return nil, nil, syscall.ENOTDIR
+		}
+		if !p.permitted(dir, 1) {
+			return nil, nil, syscall.EACCES
+		}
+		child := dir.entries[elem]
+		last := i == len(elems)-1
+		if child != nil && child.mode&fs.ModeSymlink != 0 && (!last || follow) {
+			target := child.target
+			if !path.IsAbs(target) {
+				target = path.Join(dirPath, target)
+			}
+			rest := splitPath(path.Join(append([]string{target}, elems[i+1:]...)...))
+			if rest == nil {
+				rest = []string{}
+			}
+			return nil, rest, nil
+		}
+		if last {
+			return &lookup{dir: dir, name: elem, node: child, path: path.Join(dirPath, elem)}, nil, nil
+		}
+		if child == nil {
+			return nil, nil, syscall.ENOENT
+		}
+		dir, dirPath = child, path.Join(dirPath, elem)
+	}
+	panic("unreachable")
+}
+
+func splitPath(name string) []string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil
+	}
+	return strings.Split(name[1:], "/")
+}
+
+// lookupExisting resolves name and fails with ENOENT if it does not exist.
+func (p *Process) lookupExisting(name string, follow bool) (*lookup, error) {
+	res, err := p.resolve(name, follow)
+	if err == nil && res.node == nil {
+		err = syscall.ENOENT
+	}
+	return res, err
+}
+
+// lookupParent resolves name for creating or removing it, which requires write and search permission on its directory.
+func (p *Process) lookupParent(name string) (*lookup, error) {
+	res, err := p.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if res.name == "" {
+		return nil, syscall.EBUSY
+	}
+	if !p.permitted(res.dir, 3) {
+		return nil, syscall.EACCES
+	}
+	return res, nil
+}
+
+// addEntry adds n to the directory dir under name.
+func (dir *node) addEntry(name string, n *node) {
+	dir.entries[name] = n
+	dir.mtime = time.Now()
+	if n.mode.IsDir() {
+		dir.nlink++
+	}
+}
+
+// removeEntry removes the entry name from the directory dir and returns the node it referred to.
+func (dir *node) removeEntry(name string) *node {
+	n := dir.entries[name]
+	delete(dir.entries, name)
+	dir.mtime = time.Now()
+	if n.mode.IsDir() {
+		dir.nlink--
+	}
+	return n
+}
+
+func (p *Process) OpenFile(name string, flag int, perm os.FileMode) (fsi.File, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	file, err := p.openFile(name, flag, perm)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func (p *Process) openFile(name string, flag int, perm os.FileMode) (*File, error) {
+	exclusive := flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0
+	res, err := p.resolve(name, !exclusive && flag&syscall.O_NOFOLLOW == 0)
+	if err != nil {
+		return nil, err
+	}
+	access := flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR)
+	n := res.node
+	if n == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, syscall.ENOENT
+		}
+		if !p.permitted(res.dir, 3) {
+			return nil, syscall.EACCES
+		}
+		n = p.fsys.newNode(perm&fs.ModePerm&^p.umask, p.uid, p.gid)
+		res.dir.addEntry(res.name, n)
+	} else {
+		switch {
+		case exclusive:
+			return nil, syscall.EEXIST
+		case n.mode&fs.ModeSymlink != 0:
+			return nil, syscall.ELOOP
+		case n.mode.IsDir() && access != os.O_RDONLY:
+			return nil, syscall.EISDIR
+		case access != os.O_WRONLY && !p.permitted(n, 4):
+			return nil, syscall.EACCES
+		case access != os.O_RDONLY && !p.permitted(n, 2):
+			return nil, syscall.EACCES
+		}
+		if flag&os.O_TRUNC != 0 && access != os.O_RDONLY && n.mode.IsRegular() {
+			n.data = nil
+			n.mtime = time.Now()
+		}
+	}
+	file := &File{proc: p, node: n, fd: p.fsys.nextFd, name: name, flag: flag}
+	p.fsys.nextFd++
+	p.files[file.fd] = file
+	return file, nil
+}
+
+func (p *Process) Remove(name string) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupParent(name)
+	if err == nil && res.node == nil {
+		err = syscall.ENOENT
+	}
+	if err == nil && res.node.mode.IsDir() && len(res.node.entries) > 0 {
+		err = syscall.ENOTEMPTY
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	res.dir.removeEntry(res.name).nlink--
+	return nil
+}
+
+func (p *Process) Rename(oldpath, newpath string) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	if err := p.rename(oldpath, newpath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
+func (p *Process) rename(oldpath, newpath string) error {
+	from, err := p.lookupParent(oldpath)
+	if err != nil {
+		return err
+	}
+	if from.node == nil {
+		return syscall.ENOENT
+	}
+	to, err := p.lookupParent(newpath)
+	if err != nil {
+		return err
+	}
+	if to.node == from.node {
+		return nil
+	}
+	if from.node.mode.IsDir() && strings.HasPrefix(to.path, from.path+"/") {
+		return syscall.EINVAL
+	}
+	if to.node != nil {
+		switch {
+		case from.node.mode.IsDir() && !to.node.mode.IsDir():
+			return syscall.ENOTDIR
+		case !from.node.mode.IsDir() && to.node.mode.IsDir():
+			return syscall.EISDIR
+		case to.node.mode.IsDir() && len(to.node.entries) > 0:
+			return syscall.ENOTEMPTY
+		}
+		to.dir.removeEntry(to.name).nlink--
+	}
+	to.dir.addEntry(to.name, from.dir.removeEntry(from.name))
+	return nil
+}
+
+func (p *Process) Stat(name string) (os.FileInfo, error) {
+	return p.stat("stat", name, true)
+}
+
+func (p *Process) Lstat(name string) (os.FileInfo, error) {
+	return p.stat("lstat", name, false)
+}
+
+func (p *Process) stat(op, name string, follow bool) (os.FileInfo, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupExisting(name, follow)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return newFileInfo(path.Base(res.path), res.node), nil
+}
+
+func (p *Process) Mkdir(name string, perm os.FileMode) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupParent(name)
+	if err == nil && res.node != nil {
+		err = syscall.EEXIST
+	}
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	res.dir.addEntry(res.name, p.fsys.newNode(fs.ModeDir|perm&fs.ModePerm&^p.umask, p.uid, p.gid))
+	return nil
+}
+
+func (p *Process) ReadDir(name string) ([]os.DirEntry, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupExisting(name, true)
+	if err == nil && !res.node.mode.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err == nil && !p.permitted(res.node, 4) {
+		err = syscall.EACCES
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	entries := make([]os.DirEntry, 0, len(res.node.entries))
+	for entryName, n := range res.node.entries {
+		entries = append(entries, dirEntry{newFileInfo(entryName, n)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (p *Process) Link(oldname, newname string) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	if err := p.hardLink(oldname, newname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (p *Process) hardLink(oldname, newname string) error {
+	from, err := p.lookupExisting(oldname, false)
+	if err != nil {
+		return err
+	}
+	if from.node.mode.IsDir() {
+		return syscall.EPERM
+	}
+	to, err := p.lookupParent(newname)
+	if err != nil {
+		return err
+	}
+	if to.node != nil {
+		return syscall.EEXIST
+	}
+	from.node.nlink++
+	to.dir.addEntry(to.name, from.node)
+	return nil
+}
+
+func (p *Process) Symlink(oldname, newname string) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupParent(newname)
+	if err == nil && res.node != nil {
+		err = syscall.EEXIST
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	n := p.fsys.newNode(fs.ModeSymlink|0777, p.uid, p.gid)
+	n.target = oldname
+	res.dir.addEntry(res.name, n)
+	return nil
+}
+
+func (p *Process) Readlink(name string) (string, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupExisting(name, false)
+	if err == nil && res.node.mode&fs.ModeSymlink == 0 {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return res.node.target, nil
+}
+
+func (p *Process) Chmod(name string, mode os.FileMode) error {
+	return p.changeNode("chmod", name, func(n *node) error {
+		if !p.isOwner(n) {
+			return syscall.EPERM
+		}
+		const changeable = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+		n.mode = n.mode&^changeable | mode&changeable
+		return nil
+	})
+}
+
+func (p *Process) Chown(name string, uid, gid int) error {
+	return p.changeNode("chown", name, func(n *node) error {
+		if p.uid != 0 && (p.uid != n.uid || (uid != -1 && uid != n.uid) || (gid != -1 && gid != n.gid && gid != p.gid)) {
+			return syscall.EPERM
+		}
+		if uid != -1 {
+			n.uid = uid
+		}
+		if gid != -1 {
+			n.gid = gid
+		}
+		if n.mode.IsRegular() {
+			n.mode &^= fs.ModeSetuid | fs.ModeSetgid
+		}
+		return nil
+	})
+}
+
+func (p *Process) Chtimes(name string, atime, mtime time.Time) error {
+	return p.changeNode("chtimes", name, func(n *node) error {
+		if !p.isOwner(n) {
+			return syscall.EPERM
+		}
+		n.atime, n.mtime = atime, mtime
+		return nil
+	})
+}
+
+// changeNode calls change with the node at name, following symbolic links.
+func (p *Process) changeNode(op, name string, change func(n *node) error) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupExisting(name, true)
+	if err == nil {
+		err = change(res.node)
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package memfs_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/setlog/fio"
+	"github.com/setlog/fio/memfs"
+)
+
+func TestLockContentionBetweenProcesses(t *testing.T) {
+	fsys := memfs.New()
+	uploader, consumer := fsys.NewProcess(), fsys.NewProcess()
+	uploads := fio.NewClient(fio.WithFileSystem(uploader), fio.WithLogger(nil))
+	downloads := fio.NewClient(fio.WithFileSystem(consumer), fio.WithLogger(nil))
+
+	file := uploads.OpenFile("/upload.csv", os.O_WRONLY|os.O_CREATE, 0644)
+	if _, err := downloads.TryReadFile("/upload.csv"); !errors.Is(err, fio.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	holder := downloads.LockHolder("/upload.csv")
+	if holder == nil || holder.Type != fio.WriteLock || holder.PID != uploader.Pid() {
+		t.Fatalf("expected write lock of process %d, got %+v", uploader.Pid(), holder)
+	}
+	if _, err := file.Write([]byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data := downloads.ReadFile("/upload.csv"); string(data) != "a,b\n" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
+
+func TestRecordLocksAreReleasedByClosingAnyDescriptor(t *testing.T) {
+	fsys := memfs.New()
+	owner, other := fsys.NewProcess(), fsys.NewProcess()
+	locked := openFile(t, owner, "/data", os.O_RDWR|os.O_CREATE)
+	unrelated := openFile(t, owner, "/data", os.O_RDONLY)
+	probe := openFile(t, other, "/data", os.O_RDWR)
+
+	if err := owner.FcntlFlock(locked.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_WRLCK}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.FcntlFlock(probe.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_RDLCK}); !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("expected EAGAIN, got %v", err)
+	}
+	if err := unrelated.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.FcntlFlock(probe.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_RDLCK}); err != nil {
+		t.Fatalf("expected lock to be released, got %v", err)
+	}
+}
+
+func TestRecordLockRanges(t *testing.T) {
+	fsys := memfs.New()
+	owner, other := fsys.NewProcess(), fsys.NewProcess()
+	locked := openFile(t, owner, "/data", os.O_RDWR|os.O_CREATE)
+	probe := openFile(t, other, "/data", os.O_RDWR)
+
+	if err := owner.FcntlFlock(locked.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_WRLCK, Start: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.FcntlFlock(locked.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_UNLCK, Start: 20, Len: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.FcntlFlock(probe.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_WRLCK, Len: 10}); err != nil {
+		t.Fatalf("expected bytes before the lock to be unlocked, got %v", err)
+	}
+	if err := other.FcntlFlock(probe.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_WRLCK, Start: 20, Len: 10}); err != nil {
+		t.Fatalf("expected bytes unlocked by the owner to be unlocked, got %v", err)
+	}
+	lk := &syscall.Flock_t{Type: syscall.F_WRLCK, Start: 25}
+	if err := other.FcntlFlock(probe.Fd(), syscall.F_GETLK, lk); err != nil {
+		t.Fatal(err)
+	}
+	if lk.Type != syscall.F_WRLCK || lk.Start != 30 || lk.Len != 0 || int(lk.Pid) != owner.Pid() {
+		t.Fatalf("expected write lock from 30 to EOF of process %d, got %+v", owner.Pid(), lk)
+	}
+}
+
+func TestLockHolderOfLockedFileKeepsRecordLocks(t *testing.T) {
+	fsys := memfs.New()
+	owner, other := fsys.NewProcess(), fsys.NewProcess()
+	owners := fio.NewClient(fio.WithFileSystem(owner), fio.WithLogger(nil))
+	others := fio.NewClient(fio.WithFileSystem(other), fio.WithLogger(nil))
+
+	file := owners.OpenFile("/data", os.O_WRONLY|os.O_CREATE, 0644)
+	defer file.Close()
+	if holder, err := file.LockHolder(); err != nil || holder != nil {
+		t.Fatalf("expected no lock of another process, got %+v and %v", holder, err)
+	}
+	if !others.IsLocked("/data") {
+		t.Fatal("expected the lock to be kept")
+	}
+	owners.IsLocked("/data")
+	if others.IsLocked("/data") {
+		t.Fatal("expected probing through another descriptor to release the lock")
+	}
+}
+
+func TestLockHolderOfLockedFileWithMultiLocker(t *testing.T) {
+	fsys := memfs.New()
+	owner, other := fsys.NewProcess(), fsys.NewProcess()
+	locker := fio.WithLocker(fio.MultiLocker{fio.FcntlLocker{}, fio.LockFileLocker{}})
+	owners := fio.NewClient(fio.WithFileSystem(owner), locker, fio.WithLogger(nil))
+	others := fio.NewClient(fio.WithFileSystem(other), fio.WithLocker(fio.FcntlLocker{}), fio.WithLogger(nil))
+
+	file := owners.OpenFile("/data", os.O_WRONLY|os.O_CREATE, 0644)
+	defer file.Close()
+	if holder, err := file.LockHolder(); err != nil || holder != nil {
+		t.Fatalf("expected no lock of another process, got %+v and %v", holder, err)
+	}
+	if !others.IsLocked("/data") {
+		t.Fatal("expected the lock to be kept")
+	}
+}
+
+func TestOFDLocksAreOwnedByDescriptor(t *testing.T) {
+	fsys := memfs.New()
+	proc := fsys.NewProcess()
+	client := fio.NewClient(fio.WithFileSystem(proc), fio.WithLocker(fio.OFDLocker{}), fio.WithLogger(nil))
+
+	file := client.OpenFile("/data", os.O_WRONLY|os.O_CREATE, 0644)
+	defer file.Close()
+	if err := client.TryWriteFile("/data", []byte("x")); !errors.Is(err, fio.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+	if holder := client.LockHolder("/data"); holder == nil || holder.PID != -1 {
+		t.Fatalf("expected descriptor-owned lock, got %+v", holder)
+	}
+}
+
+func TestFlock(t *testing.T) {
+	fsys := memfs.New()
+	proc := fsys.NewProcess()
+	first := openFile(t, proc, "/data", os.O_RDONLY|os.O_CREATE)
+	second := openFile(t, proc, "/data", os.O_RDONLY)
+
+	if err := proc.Flock(first.Fd(), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.Flock(second.Fd(), syscall.LOCK_EX|syscall.LOCK_NB); !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Fatalf("expected EWOULDBLOCK, got %v", err)
+	}
+	if err := proc.FcntlFlock(second.Fd(), syscall.F_SETLK, &syscall.Flock_t{Type: syscall.F_RDLCK}); err != nil {
+		t.Fatalf("expected flock(2) and fcntl(2) locks not to interact, got %v", err)
+	}
+
+	locked := make(chan error)
+	go func() { locked <- proc.Flock(second.Fd(), syscall.LOCK_EX) }()
+	select {
+	case err := <-locked:
+		t.Fatalf("expected Flock to wait, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-locked; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	fsys := memfs.New()
+	owner, other, root := fsys.NewProcessAs(1000, 1000), fsys.NewProcessAs(1001, 1001), fsys.NewProcessAs(0, 0)
+
+	openFile(t, owner, "/secret", os.O_WRONLY|os.O_CREATE).Close()
+	if info, err := owner.Stat("/secret"); err != nil || info.Mode() != 0644 {
+		t.Fatalf("expected mode 0644 after umask, got %v and %v", info, err)
+	}
+	if err := owner.Chmod("/secret", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.OpenFile("/secret", os.O_RDONLY, 0); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+	if err := other.Chmod("/secret", 0666); !errors.Is(err, syscall.EPERM) {
+		t.Fatalf("expected EPERM, got %v", err)
+	}
+	openFile(t, root, "/secret", os.O_RDWR).Close()
+
+	if err := owner.Mkdir("/private", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.OpenFile("/private/file", os.O_WRONLY|os.O_CREATE, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+}
+
+func TestLockFileInReadOnlyDirectory(t *testing.T) {
+	fsys := memfs.New()
+	root, user := fsys.NewProcessAs(0, 0), fsys.NewProcessAs(1000, 1000)
+	if err := root.Mkdir("/ro", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fio.NewClient(fio.WithFileSystem(root), fio.WithPerm(0644), fio.WithLogger(nil)).WriteFile("/ro/data", []byte("x"))
+	client := fio.NewClient(fio.WithFileSystem(user), fio.WithLocker(fio.LockFileLocker{}), fio.WithLogger(nil))
+
+	_, err := client.TryReadFile("/ro/data")
+	if !errors.Is(err, fs.ErrPermission) || errors.Is(err, fio.ErrLocked) {
+		t.Fatalf("expected permission error, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err = client.TryReadFileContext(ctx, "/ro/data"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected permission error without waiting, got %v", err)
+	}
+}
+
+func TestWaitingForUnreadableFile(t *testing.T) {
+	fsys := memfs.New()
+	root, user := fsys.NewProcessAs(0, 0), fsys.NewProcessAs(1000, 1000)
+	fio.NewClient(fio.WithFileSystem(root), fio.WithPerm(0600), fio.WithLogger(nil)).WriteFile("/upload", []byte("x"))
+	client := fio.NewClient(fio.WithFileSystem(user), fio.WithLogger(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := client.TryWaitUntilUnlocked(ctx, "/upload"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected permission error without waiting, got %v", err)
+	}
+	if err := client.TryWaitUntilStable(ctx, "/upload", time.Millisecond); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected permission error without waiting, got %v", err)
+	}
+}
+
+func TestDirectoriesAndLinks(t *testing.T) {
+	fsys := memfs.New()
+	proc := fsys.NewProcess()
+	client := fio.NewClient(fio.WithFileSystem(proc), fio.WithLogger(nil))
+
+	if err := proc.Mkdir("/in", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.Symlink("in", "/inbox"); err != nil {
+		t.Fatal(err)
+	}
+	client.WriteFile("/inbox/b", []byte("b"))
+	client.WriteFile("/in/a", []byte("a"))
+	if err := proc.Link("/in/a", "/in/c"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := proc.ReadDir("/inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Name() != "a" || entries[1].Name() != "b" || entries[2].Name() != "c" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if info, err := proc.Lstat("/inbox"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("expected symbolic link, got %v and %v", info, err)
+	}
+	if err := proc.Remove("/in"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("expected ENOTEMPTY, got %v", err)
+	}
+	if err := proc.Rename("/in", "/in/sub"); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("expected EINVAL, got %v", err)
+	}
+	client.RemoveFile("/in/a")
+	if data := client.ReadFile("/in/c"); string(data) != "a" {
+		t.Fatalf("expected hard link to keep content, got %q", data)
+	}
+}
+
+func TestCopyAndMoveFile(t *testing.T) {
+	fsys := memfs.New()
+	proc := fsys.NewProcess()
+	client := fio.NewClient(fio.WithFileSystem(proc), fio.WithLogger(nil))
+
+	client.WriteFileAtomic("/source", []byte("payload"))
+	client.SetXattr("/source", "user.origin", []byte("test"))
+	var result fio.Result
+	client.CopyFile("/source", "/copy", fio.WithPreserve(fio.PreserveAll|fio.PreserveStrict), fio.WithResult(&result))
+	if result.Copy != fio.CopyClone {
+		t.Fatalf("expected %v, got %v", fio.CopyClone, result.Copy)
+	}
+	if value := client.GetXattr("/copy", "user.origin"); string(value) != "test" {
+		t.Fatalf("expected extended attribute to be preserved, got %q", value)
+	}
+	client.MoveFile("/copy", "/moved", fio.WithResult(&result))
+	if result.Move != fio.MoveRename || string(client.ReadFile("/moved")) != "payload" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := proc.Stat("/copy"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected source to be moved, got %v", err)
+	}
+}
+
+func TestExitReleasesLocks(t *testing.T) {
+	fsys := memfs.New()
+	crashed, survivor := fsys.NewProcess(), fsys.NewProcess()
+
+	fio.NewClient(fio.WithFileSystem(crashed)).OpenFile("/data", os.O_WRONLY|os.O_CREATE, 0644)
+	crashed.Exit()
+	if locked := fio.NewClient(fio.WithFileSystem(survivor)).IsLocked("/data"); locked {
+		t.Fatal("expected locks to be released")
+	}
+}
+
+func openFile(t *testing.T, proc *memfs.Process, name string, flag int) *memfs.File {
+	t.Helper()
+	file, err := proc.OpenFile(name, flag, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.(*memfs.File)
+}
@@ -0,0 +1,141 @@
+package memfs
+
+import (
+	"io/fs"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Flags of setxattr(2).
+const (
+	xattrCreate  = 1
+	xattrReplace = 2
+)
+
+// Listxattr stores the names of the extended attributes of the file at path in dest like listxattr(2).
+// Attributes in the trusted namespace are only listed for processes of user 0.
+func (p *Process) Listxattr(path string, dest []byte) (int, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	res, err := p.lookupExisting(path, true)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(res.node.xattrs))
+	for name := range res.node.xattrs {
+		if p.uid == 0 || !strings.HasPrefix(name, "trusted.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var list []byte
+	for _, name := range names {
+		list = append(append(list, name...), 0)
+	}
+	return copyXattr(dest, list)
+}
+
+func (p *Process) Getxattr(path, attr string, dest []byte) (int, error) {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	n, err := p.xattrNode(path, attr, false)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := n.xattrs[attr]
+	if !ok {
+		return 0, errNoXattr
+	}
+	return copyXattr(dest, value)
+}
+
+func (p *Process) Setxattr(path, attr string, data []byte, flags int) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	n, err := p.xattrNode(path, attr, true)
+	if err != nil {
+		return err
+	}
+	_, exists := n.xattrs[attr]
+	switch {
+	case flags&^(xattrCreate|xattrReplace) != 0:
+		return syscall.EINVAL
+	case flags&xattrCreate != 0 && exists:
+		return syscall.EEXIST
+	case flags&xattrReplace != 0 && !exists:
+		return errNoXattr
+	}
+	if n.xattrs == nil {
+		n.xattrs = map[string][]byte{}
+	}
+	n.xattrs[attr] = append([]byte{}, data...)
+	return nil
+}
+
+func (p *Process) Removexattr(path, attr string) error {
+	p.fsys.mu.Lock()
+	defer p.fsys.mu.Unlock()
+	n, err := p.xattrNode(path, attr, true)
+	if err != nil {
+		return err
+	}
+	if _, ok := n.xattrs[attr]; !ok {
+		return errNoXattr
+	}
+	delete(n.xattrs, attr)
+	return nil
+}
+
+// xattrNode returns the node at path if p may read, or change if write is set, its extended attribute attr.
+// Only the user, trusted and security namespaces are supported; changing attributes outside of the user
+// namespace requires user 0.
+func (p *Process) xattrNode(path, attr string, write bool) (*node, error) {
+	res, err := p.lookupExisting(path, true)
+	if err != nil {
+		return nil, err
+	}
+	n := res.node
+	switch {
+	case strings.HasPrefix(attr, "user."):
+		if !n.mode.IsRegular() && !n.mode.IsDir() {
+			if write {
+				return nil, syscall.EPERM
+			}
+			return nil, errNoXattr
+		}
+		want := fs.FileMode(4)
+		if write {
+			want = 2
+		}
+		if !p.permitted(n, want) {
+			return nil, syscall.EACCES
+		}
+	case strings.HasPrefix(attr, "trusted."):
+		if p.uid != 0 {
+			if write {
+				return nil, syscall.EPERM
+			}
+			return nil, errNoXattr
+		}
+	case strings.HasPrefix(attr, "security."):
+		if write && p.uid != 0 {
+			return nil, syscall.EPERM
+		}
+	default:
+		return nil, syscall.EOPNOTSUPP
+	}
+	return n, nil
+}
+
+// copyXattr copies data to dest like the xattr system calls do: if dest is empty,
+// only the size of data is returned, and if it is too small, ERANGE.
+func copyXattr(dest, data []byte) (int, error) {
+	if len(dest) == 0 {
+		return len(data), nil
+	}
+	if len(dest) < len(data) {
+		return 0, syscall.ERANGE
+	}
+	return copy(dest, data), nil
+}
@@ -0,0 +1,6 @@
+package memfs
+
+import "syscall"
+
+// errNoXattr is the error of the xattr system calls for attributes which do not exist.
+const errNoXattr = syscall.ENODATA
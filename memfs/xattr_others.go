@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package memfs
+
+import "syscall"
+
+// errNoXattr is the error of the xattr system calls for attributes which do not exist.
+// Linux reports ENODATA, which the syscall package only defines there.
+const errNoXattr = syscall.ENOATTR
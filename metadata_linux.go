@@ -0,0 +1,80 @@
+package fio
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// preserveMetadata applies the metadata of src selected by o.preserve to the file at filePath, which must be locked.
+// The owner is changed first because doing so clears the setuid and setgid bits, and the timestamps last
+// because changing extended attributes may update them.
+func preserveMetadata(o *options, filePath string, src *sourceFile) error {
+	if o.preserve&PreserveAll == 0 {
+		return nil
+	}
+	stat, _ := src.info.Sys().(*syscall.Stat_t)
+	if o.preserve&PreserveOwner != 0 && stat != nil {
+		err := o.fs.Chown(filePath, int(stat.Uid), int(stat.Gid))
+		if err = skipUnprivileged(o, filePath, "owner", err); err != nil {
+			return fmt.Errorf("preserve owner: %w", err)
+		}
+	}
+	if o.preserve&PreserveMode != 0 {
+		mode := src.info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		if err := o.fs.Chmod(filePath, mode); err != nil {
+			return fmt.Errorf("preserve mode: %w", err)
+		}
+	}
+	if o.preserve&PreserveXattrs != 0 {
+		if err := preserveXattrs(o, filePath, src.path); err != nil {
+			return fmt.Errorf("preserve extended attributes: %w", err)
+		}
+	}
+	if o.preserve&PreserveTimestamps != 0 {
+		atime, mtime := src.info.ModTime(), src.info.ModTime()
+		if stat != nil {
+			atime = time.Unix(stat.Atim.Unix())
+			mtime = time.Unix(stat.Mtim.Unix())
+		}
+		if err := o.fs.Chtimes(filePath, atime, mtime); err != nil {
+			return fmt.Errorf("preserve timestamps: %w", err)
+		}
+	}
+	return nil
+}
+
+func preserveXattrs(o *options, filePath, srcFilePath string) error {
+	names, err := listXattrs(o, srcFilePath)
+	if err != nil {
+		return skipUnprivileged(o, filePath, "extended attributes", err)
+	}
+	for _, name := range names {
+		value, err := getXattr(o, srcFilePath, name)
+		if err == nil {
+			err = o.fs.Setxattr(filePath, name, value, 0)
+		}
+		if err = skipUnprivileged(o, filePath, "extended attribute "+name, err); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// skipUnprivileged returns nil if err means that metadata could not be applied to the file at filePath because the
+// process lacks privileges or the file system lacks support, and o.preserve does not include PreserveStrict.
+// Skipped metadata is logged.
+func skipUnprivileged(o *options, filePath, what string, err error) error {
+	if err == nil || o.preserve&PreserveStrict != 0 {
+		return err
+	}
+	if !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.ENOTSUP) && !errors.Is(err, syscall.EOPNOTSUPP) {
+		return err
+	}
+	if log := o.log; log != nil {
+		log.Printf("Skipped preserving %s of '%s': %v.", what, filePath, err)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	os "os"
 	reflect "reflect"
 	syscall "syscall"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	fsi "github.com/setlog/fio/fsi"
@@ -93,6 +94,79 @@ func (mr *MockFileMockRecorder) Read(p interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockFile)(nil).Read), p)
 }
 
+// ReadAt mocks base method.
+func (m *MockFile) ReadAt(p []byte, off int64) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadAt", p, off)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAt indicates an expected call of ReadAt.
+func (mr *MockFileMockRecorder) ReadAt(p, off interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAt", reflect.TypeOf((*MockFile)(nil).ReadAt), p, off)
+}
+
+// Seek mocks base method.
+func (m *MockFile) Seek(offset int64, whence int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Seek", offset, whence)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Seek indicates an expected call of Seek.
+func (mr *MockFileMockRecorder) Seek(offset, whence interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Seek", reflect.TypeOf((*MockFile)(nil).Seek), offset, whence)
+}
+
+// Stat mocks base method.
+func (m *MockFile) Stat() (os.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat")
+	ret0, _ := ret[0].(os.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockFileMockRecorder) Stat() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockFile)(nil).Stat))
+}
+
+// Sync mocks base method.
+func (m *MockFile) Sync() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sync")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Sync indicates an expected call of Sync.
+func (mr *MockFileMockRecorder) Sync() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sync", reflect.TypeOf((*MockFile)(nil).Sync))
+}
+
+// Truncate mocks base method.
+func (m *MockFile) Truncate(size int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Truncate", size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Truncate indicates an expected call of Truncate.
+func (mr *MockFileMockRecorder) Truncate(size interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Truncate", reflect.TypeOf((*MockFile)(nil).Truncate), size)
+}
+
 // Write mocks base method.
 func (m *MockFile) Write(p []byte) (int, error) {
 	m.ctrl.T.Helper()
@@ -108,6 +182,21 @@ func (mr *MockFileMockRecorder) Write(p interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockFile)(nil).Write), p)
 }
 
+// WriteAt mocks base method.
+func (m *MockFile) WriteAt(p []byte, off int64) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteAt", p, off)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WriteAt indicates an expected call of WriteAt.
+func (mr *MockFileMockRecorder) WriteAt(p, off interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteAt", reflect.TypeOf((*MockFile)(nil).WriteAt), p, off)
+}
+
 // MockFileSystem is a mock of FileSystem interface.
 type MockFileSystem struct {
 	ctrl     *gomock.Controller
@@ -131,6 +220,63 @@ func (m *MockFileSystem) EXPECT() *MockFileSystemMockRecorder {
 	return m.recorder
 }
 
+// Chmod mocks base method.
+func (m *MockFileSystem) Chmod(name string, mode os.FileMode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Chmod", name, mode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Chmod indicates an expected call of Chmod.
+func (mr *MockFileSystemMockRecorder) Chmod(name, mode interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Chmod", reflect.TypeOf((*MockFileSystem)(nil).Chmod), name, mode)
+}
+
+// Chown mocks base method.
+func (m *MockFileSystem) Chown(name string, uid, gid int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Chown", name, uid, gid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Chown indicates an expected call of Chown.
+func (mr *MockFileSystemMockRecorder) Chown(name, uid, gid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Chown", reflect.TypeOf((*MockFileSystem)(nil).Chown), name, uid, gid)
+}
+
+// Chtimes mocks base method.
+func (m *MockFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Chtimes", name, atime, mtime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Chtimes indicates an expected call of Chtimes.
+func (mr *MockFileSystemMockRecorder) Chtimes(name, atime, mtime interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Chtimes", reflect.TypeOf((*MockFileSystem)(nil).Chtimes), name, atime, mtime)
+}
+
+// CopyFileRange mocks base method.
+func (m *MockFileSystem) CopyFileRange(srcFd uintptr, srcOff *int64, dstFd uintptr, dstOff *int64, length, flags int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFileRange", srcFd, srcOff, dstFd, dstOff, length, flags)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyFileRange indicates an expected call of CopyFileRange.
+func (mr *MockFileSystemMockRecorder) CopyFileRange(srcFd, srcOff, dstFd, dstOff, length, flags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyFileRange", reflect.TypeOf((*MockFileSystem)(nil).CopyFileRange), srcFd, srcOff, dstFd, dstOff, length, flags)
+}
+
 // FcntlFlock mocks base method.
 func (m *MockFileSystem) FcntlFlock(fd uintptr, cmd int, lk *syscall.Flock_t) error {
 	m.ctrl.T.Helper()
@@ -145,6 +291,121 @@ func (mr *MockFileSystemMockRecorder) FcntlFlock(fd, cmd, lk interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FcntlFlock", reflect.TypeOf((*MockFileSystem)(nil).FcntlFlock), fd, cmd, lk)
 }
 
+// Fdatasync mocks base method.
+func (m *MockFileSystem) Fdatasync(fd uintptr) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fdatasync", fd)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Fdatasync indicates an expected call of Fdatasync.
+func (mr *MockFileSystemMockRecorder) Fdatasync(fd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fdatasync", reflect.TypeOf((*MockFileSystem)(nil).Fdatasync), fd)
+}
+
+// Flock mocks base method.
+func (m *MockFileSystem) Flock(fd uintptr, how int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Flock", fd, how)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Flock indicates an expected call of Flock.
+func (mr *MockFileSystemMockRecorder) Flock(fd, how interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Flock", reflect.TypeOf((*MockFileSystem)(nil).Flock), fd, how)
+}
+
+// Getxattr mocks base method.
+func (m *MockFileSystem) Getxattr(path, attr string, dest []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Getxattr", path, attr, dest)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Getxattr indicates an expected call of Getxattr.
+func (mr *MockFileSystemMockRecorder) Getxattr(path, attr, dest interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Getxattr", reflect.TypeOf((*MockFileSystem)(nil).Getxattr), path, attr, dest)
+}
+
+// IoctlFileClone mocks base method.
+func (m *MockFileSystem) IoctlFileClone(dstFd, srcFd uintptr) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IoctlFileClone", dstFd, srcFd)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IoctlFileClone indicates an expected call of IoctlFileClone.
+func (mr *MockFileSystemMockRecorder) IoctlFileClone(dstFd, srcFd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IoctlFileClone", reflect.TypeOf((*MockFileSystem)(nil).IoctlFileClone), dstFd, srcFd)
+}
+
+// Link mocks base method.
+func (m *MockFileSystem) Link(oldname, newname string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Link", oldname, newname)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Link indicates an expected call of Link.
+func (mr *MockFileSystemMockRecorder) Link(oldname, newname interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Link", reflect.TypeOf((*MockFileSystem)(nil).Link), oldname, newname)
+}
+
+// Listxattr mocks base method.
+func (m *MockFileSystem) Listxattr(path string, dest []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Listxattr", path, dest)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Listxattr indicates an expected call of Listxattr.
+func (mr *MockFileSystemMockRecorder) Listxattr(path, dest interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Listxattr", reflect.TypeOf((*MockFileSystem)(nil).Listxattr), path, dest)
+}
+
+// Lstat mocks base method.
+func (m *MockFileSystem) Lstat(name string) (os.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lstat", name)
+	ret0, _ := ret[0].(os.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lstat indicates an expected call of Lstat.
+func (mr *MockFileSystemMockRecorder) Lstat(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lstat", reflect.TypeOf((*MockFileSystem)(nil).Lstat), name)
+}
+
+// Mkdir mocks base method.
+func (m *MockFileSystem) Mkdir(name string, perm os.FileMode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Mkdir", name, perm)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Mkdir indicates an expected call of Mkdir.
+func (mr *MockFileSystemMockRecorder) Mkdir(name, perm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Mkdir", reflect.TypeOf((*MockFileSystem)(nil).Mkdir), name, perm)
+}
+
 // OpenFile mocks base method.
 func (m *MockFileSystem) OpenFile(name string, flag int, perm os.FileMode) (fsi.File, error) {
 	m.ctrl.T.Helper()
@@ -160,6 +421,36 @@ func (mr *MockFileSystemMockRecorder) OpenFile(name, flag, perm interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenFile", reflect.TypeOf((*MockFileSystem)(nil).OpenFile), name, flag, perm)
 }
 
+// ReadDir mocks base method.
+func (m *MockFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadDir", name)
+	ret0, _ := ret[0].([]os.DirEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadDir indicates an expected call of ReadDir.
+func (mr *MockFileSystemMockRecorder) ReadDir(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadDir", reflect.TypeOf((*MockFileSystem)(nil).ReadDir), name)
+}
+
+// Readlink mocks base method.
+func (m *MockFileSystem) Readlink(name string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Readlink", name)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Readlink indicates an expected call of Readlink.
+func (mr *MockFileSystemMockRecorder) Readlink(name interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Readlink", reflect.TypeOf((*MockFileSystem)(nil).Readlink), name)
+}
+
 // Remove mocks base method.
 func (m *MockFileSystem) Remove(name string) error {
 	m.ctrl.T.Helper()
@@ -174,6 +465,63 @@ func (mr *MockFileSystemMockRecorder) Remove(name interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Remove", reflect.TypeOf((*MockFileSystem)(nil).Remove), name)
 }
 
+// Removexattr mocks base method.
+func (m *MockFileSystem) Removexattr(path, attr string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Removexattr", path, attr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Removexattr indicates an expected call of Removexattr.
+func (mr *MockFileSystemMockRecorder) Removexattr(path, attr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Removexattr", reflect.TypeOf((*MockFileSystem)(nil).Removexattr), path, attr)
+}
+
+// Rename mocks base method.
+func (m *MockFileSystem) Rename(oldpath, newpath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rename", oldpath, newpath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Rename indicates an expected call of Rename.
+func (mr *MockFileSystemMockRecorder) Rename(oldpath, newpath interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rename", reflect.TypeOf((*MockFileSystem)(nil).Rename), oldpath, newpath)
+}
+
+// Sendfile mocks base method.
+func (m *MockFileSystem) Sendfile(dstFd, srcFd uintptr, offset *int64, count int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sendfile", dstFd, srcFd, offset, count)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Sendfile indicates an expected call of Sendfile.
+func (mr *MockFileSystemMockRecorder) Sendfile(dstFd, srcFd, offset, count interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sendfile", reflect.TypeOf((*MockFileSystem)(nil).Sendfile), dstFd, srcFd, offset, count)
+}
+
+// Setxattr mocks base method.
+func (m *MockFileSystem) Setxattr(path, attr string, data []byte, flags int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Setxattr", path, attr, data, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Setxattr indicates an expected call of Setxattr.
+func (mr *MockFileSystemMockRecorder) Setxattr(path, attr, data, flags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Setxattr", reflect.TypeOf((*MockFileSystem)(nil).Setxattr), path, attr, data, flags)
+}
+
 // Stat mocks base method.
 func (m *MockFileSystem) Stat(name string) (os.FileInfo, error) {
 	m.ctrl.T.Helper()
@@ -188,3 +536,17 @@ func (mr *MockFileSystemMockRecorder) Stat(name interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockFileSystem)(nil).Stat), name)
 }
+
+// Symlink mocks base method.
+func (m *MockFileSystem) Symlink(oldname, newname string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Symlink", oldname, newname)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Symlink indicates an expected call of Symlink.
+func (mr *MockFileSystemMockRecorder) Symlink(oldname, newname interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Symlink", reflect.TypeOf((*MockFileSystem)(nil).Symlink), oldname, newname)
+}
@@ -0,0 +1,182 @@
+package fio
+
+import (
+	"context"
+	"hash"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/setlog/fio/fsi"
+)
+
+// Option configures a single call of an API function, overriding the package-level defaults.
+type Option func(*options)
+
+// WithLocker makes the call claim its advisory locks using locker instead of DefaultLocker.
+func WithLocker(locker Locker) Option {
+	return func(o *options) {
+		o.locker = locker
+	}
+}
+
+// WithAtomicWrite makes the call write its destination file atomically: data is written to a
+// locked temporary file in the same directory, which is synced to disk and then renamed over
+// the destination, followed by syncing the directory. Readers therefore never observe partially
+// written content, and a crash leaves either the previous or the new content in place.
+// The permissions of a replaced destination file are preserved. If the destination is a symbolic link,
+// the file it points to is replaced; destinations which are not regular files, such as devices, are rejected.
+//
+// This option affects all functions which write files, including CopyFile and MoveFile.
+func WithAtomicWrite() Option {
+	return func(o *options) {
+		o.atomic = true
+	}
+}
+
+// WithPreserveOnFailure makes the call leave the previous content of an existing destination file
+// untouched if the data to write cannot be read completely, e.g. because the source of a copy or the
+// reader passed to WriteFileWithReader fails. To achieve this, the data is first staged in a temporary
+// file next to the destination, and the destination is only truncated and overwritten once all data
+// has been received.
+//
+// Unlike WithAtomicWrite, the destination keeps its inode, ownership and hard links, but an error while
+// overwriting it with the staged data, such as a full disk, can still leave it partially written.
+//
+// Independently of this option, a destination is only removed after a failed write if it was created by the call.
+func WithPreserveOnFailure() Option {
+	return func(o *options) {
+		o.preserveOnFailure = true
+	}
+}
+
+// WithDurability makes the call flush written files to stable storage as described by durability
+// instead of DefaultDurability.
+func WithDurability(durability Durability) Option {
+	return func(o *options) {
+		o.durability = durability
+	}
+}
+
+// WithDenseCopy makes CopyFile and MoveFile write every byte of a sparse source file to the destination,
+// allocating disk space for its holes, instead of recreating the holes in the destination.
+func WithDenseCopy() Option {
+	return func(o *options) {
+		o.denseCopy = true
+	}
+}
+
+// WithPreserve makes CopyFile and MoveFile apply the metadata of the source file selected by preserve
+// to the destination while holding the write lock on it. By default, only the permissions are carried over,
+// and only to newly created destination files.
+//
+// Changing the owner of a file usually requires root privileges (CAP_CHOWN), and extended attributes outside
+// the user namespace may require further privileges. Unless preserve includes PreserveStrict, metadata which
+// cannot be applied for these reasons is skipped. Moving a file by renaming it always preserves all metadata.
+func WithPreserve(preserve Preserve) Option {
+	return func(o *options) {
+		o.preserve = preserve
+	}
+}
+
+// WithChecksum makes the call compute a digest of the data it writes using a hash created by newHash,
+// such as sha256.New, sha512.New or CRC32C. Once all data has been written, the destination file is read
+// back and the call fails with an error matching ErrChecksumMismatch if its digest differs. MoveFile only
+// removes the source after the verification succeeded. The digest can be retrieved with WithResult().
+//
+// Since the data must pass through the process to be hashed, this option disables the copy methods
+// which transfer data within the kernel, including cloning and sparse copying.
+func WithChecksum(newHash func() hash.Hash) Option {
+	return func(o *options) {
+		o.checksum = newHash
+	}
+}
+
+// WithProgress makes the call report the progress of the data it writes to report, at most once per interval
+// and once more after completing successfully. The total amount of bytes is known for CopyFile, MoveFile and
+// WriteFile, and for WriteFileWithReader if the reader has a Len() method, like *bytes.Reader.
+//
+// report is called on the goroutine of the call and should return quickly. To receive the progress on a channel,
+// send to it from report, preferably without blocking.
+func WithProgress(interval time.Duration, report func(Progress)) Option {
+	return func(o *options) {
+		o.progress = &progressTracker{interval: interval, report: report}
+	}
+}
+
+// WithResult makes the call store details about how it was carried out in result,
+// such as the strategy used by MoveFile. result is reset at the start of the call.
+// It is ignored by NewClient and must be passed to each call instead.
+func WithResult(result *Result) Option {
+	return func(o *options) {
+		o.result = result
+	}
+}
+
+// WithFileSystem makes the call access files through fsys instead of the operating system.
+// Use this to run code using fio against a mock or an in-memory file system.
+func WithFileSystem(fsys fsi.FileSystem) Option {
+	return func(o *options) {
+		o.fs = fsys
+	}
+}
+
+// WithLogger makes the call write its logs to logger instead of the logger selected by IsLoggingEnabled
+// and Logger. A nil logger disables logging.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.log = logger
+	}
+}
+
+// WithPerm makes the call create files with permissions perm instead of 0660 (before umask), unless the
+// function takes the permissions as an argument, like WriteFilePerm.
+func WithPerm(perm fs.FileMode) Option {
+	return func(o *options) {
+		o.perm = perm
+	}
+}
+
+// options holds the settings for a single call of an API function.
+type options struct {
+	// ctx bounds the time spent waiting for advisory locks held by other processes and transferring data.
+	// If ctx is nil, claiming a lock fails immediately if a conflicting lock is held.
+	ctx               context.Context
+	fs                fsi.FileSystem
+	log               *log.Logger
+	perm              fs.FileMode
+	locker            Locker
+	atomic            bool
+	preserveOnFailure bool
+	durability        Durability
+	denseCopy         bool
+	preserve          Preserve
+	checksum          func() hash.Hash
+	progress          *progressTracker
+	// result is never nil; it points to a discarded Result unless WithResult is used.
+	result *Result
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		fs:         fsApi,
+		log:        logger(),
+		perm:       0660,
+		locker:     DefaultLocker,
+		durability: DefaultDurability,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.result == nil {
+		o.result = &Result{}
+	}
+	*o.result = Result{}
+	return o
+}
+
+func newContextOptions(ctx context.Context, opts []Option) *options {
+	o := newOptions(opts)
+	o.ctx = ctx
+	return o
+}
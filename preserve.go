@@ -0,0 +1,24 @@
+package fio
+
+// Preserve selects the metadata of the source file which CopyFile and MoveFile apply to the destination.
+// The flags can be combined with |. See WithPreserve.
+type Preserve int
+
+const (
+	// PreserveMode preserves the permissions and the setuid, setgid and sticky bits.
+	PreserveMode Preserve = 1 << iota
+	// PreserveOwner preserves the owning user and group.
+	PreserveOwner
+	// PreserveTimestamps preserves the access and modification times.
+	PreserveTimestamps
+	// PreserveXattrs preserves all extended attributes, including POSIX ACLs, which are stored in
+	// the extended attributes system.posix_acl_access and system.posix_acl_default.
+	PreserveXattrs
+	// PreserveStrict makes the call fail if metadata cannot be preserved because the process lacks
+	// the privileges to do so or the destination file system does not support extended attributes.
+	// Without it, such metadata is skipped and the failure is logged.
+	PreserveStrict
+
+	// PreserveAll preserves all metadata supported by fio.
+	PreserveAll = PreserveMode | PreserveOwner | PreserveTimestamps | PreserveXattrs
+)
@@ -0,0 +1,81 @@
+package fio
+
+import "time"
+
+// Progress describes the state of a transfer when it is reported. See WithProgress.
+type Progress struct {
+	// Bytes is the amount of bytes transferred so far.
+	Bytes int64
+	// Total is the amount of bytes to transfer, or -1 if unknown, e.g. when writing from an arbitrary io.Reader.
+	Total int64
+	// Elapsed is the time passed since the transfer started.
+	Elapsed time.Duration
+	// Done is true for the final report of a successful transfer.
+	Done bool
+}
+
+// BytesPerSecond returns the average throughput of the transfer so far.
+func (p Progress) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// Remaining estimates the time until the transfer completes based on the average throughput so far.
+// It returns -1 if the total is unknown or nothing has been transferred yet.
+func (p Progress) Remaining() time.Duration {
+	if p.Total < 0 || p.Bytes == 0 {
+		return -1
+	}
+	if p.Bytes >= p.Total {
+		return 0
+	}
+	return time.Duration(float64(p.Elapsed) * float64(p.Total-p.Bytes) / float64(p.Bytes))
+}
+
+// progressTracker counts the bytes transferred by a call and reports them to the function passed to WithProgress.
+// All methods do nothing if the tracker is nil.
+type progressTracker struct {
+	interval   time.Duration
+	report     func(Progress)
+	start      time.Time
+	lastReport time.Time
+	bytes      int64
+	total      int64
+}
+
+// begin starts tracking a transfer of total bytes, or of an unknown amount if total is -1.
+func (t *progressTracker) begin(total int64) {
+	if t == nil {
+		return
+	}
+	t.start = time.Now()
+	t.lastReport = t.start
+	t.bytes = 0
+	t.total = total
+}
+
+// add counts n transferred bytes and reports the progress if the interval has passed since the last report.
+func (t *progressTracker) add(n int64) {
+	if t == nil {
+		return
+	}
+	t.bytes += n
+	if now := time.Now(); now.Sub(t.lastReport) >= t.interval {
+		t.lastReport = now
+		t.report(t.progress(now, false))
+	}
+}
+
+// finish reports the completion of the transfer.
+func (t *progressTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.report(t.progress(time.Now(), true))
+}
+
+func (t *progressTracker) progress(now time.Time, done bool) Progress {
+	return Progress{Bytes: t.bytes, Total: t.total, Elapsed: now.Sub(t.start), Done: done}
+}
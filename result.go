@@ -0,0 +1,76 @@
+package fio
+
+// Result receives details about how a call of an API function was carried out. See WithResult.
+type Result struct {
+	// Bytes is the amount of bytes written, copied or moved.
+	Bytes int64
+	// Move is the strategy used by MoveFile.
+	Move MoveStrategy
+	// Copy is the method used to transfer the data of a file.
+	Copy CopyMethod
+	// Digest is the verified digest of the written data if WithChecksum() was used.
+	Digest []byte
+}
+
+// MoveStrategy describes how MoveFile moved a file.
+type MoveStrategy int
+
+const (
+	// MoveNone means that no file was moved.
+	MoveNone MoveStrategy = iota
+	// MoveRename means that the file was renamed with rename(2).
+	MoveRename
+	// MoveCopy means that the file was copied and the source removed afterwards,
+	// because the source and destination are on different mounts.
+	MoveCopy
+)
+
+func (s MoveStrategy) String() string {
+	switch s {
+	case MoveNone:
+		return "none"
+	case MoveRename:
+		return "rename"
+	case MoveCopy:
+		return "copy and remove"
+	}
+	return "unknown move strategy"
+}
+
+// CopyMethod describes how the data of a file was transferred.
+type CopyMethod int
+
+const (
+	// CopyNone means that no data was transferred.
+	CopyNone CopyMethod = iota
+	// CopyClone means that the destination was made to share the data of the source
+	// with the FICLONE ioctl, which is only supported by copy-on-write file systems.
+	CopyClone
+	// CopyFileRange means that the data was copied within the kernel with copy_file_range(2).
+	CopyFileRange
+	// CopySendfile means that the data was copied within the kernel with sendfile(2).
+	CopySendfile
+	// CopySparse means that only the data segments of a sparse file were copied,
+	// leaving the holes between them unallocated in the destination.
+	CopySparse
+	// CopyBuffered means that the data was read into and written from a buffer in user space.
+	CopyBuffered
+)
+
+func (m CopyMethod) String() string {
+	switch m {
+	case CopyNone:
+		return "none"
+	case CopyClone:
+		return "clone"
+	case CopyFileRange:
+		return "copy_file_range"
+	case CopySendfile:
+		return "sendfile"
+	case CopySparse:
+		return "sparse copy"
+	case CopyBuffered:
+		return "buffered copy"
+	}
+	return "unknown copy method"
+}
@@ -0,0 +1,59 @@
+package fio
+
+import (
+	"context"
+	"time"
+)
+
+// waitUntilUnlocked polls the lock state of the file at filePath with the same backoff used for claiming locks.
+// Only a lock held on the file is waited for; failures to query the lock state are returned immediately.
+func waitUntilUnlocked(ctx context.Context, o *options, filePath string) error {
+	var queryErr error
+	err := waitForLock(ctx, func() error {
+		var holder *LockInfo
+		holder, queryErr = lockHolder(o, filePath)
+		if queryErr == nil && holder != nil {
+			return ErrLocked
+		}
+		return nil
+	})
+	if queryErr != nil {
+		return queryErr
+	}
+	return err
+}
+
+// waitUntilStable waits for the file at filePath to be unlocked, then checks whether its size or modification time
+// change within quietPeriod and whether it got locked again, starting over if so.
+func waitUntilStable(ctx context.Context, o *options, filePath string, quietPeriod time.Duration) error {
+	for {
+		if err := waitUntilUnlocked(ctx, o, filePath); err != nil {
+			return err
+		}
+		before, err := o.fs.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		timer := time.NewTimer(quietPeriod)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		after, err := o.fs.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+			continue
+		}
+		holder, err := lockHolder(o, filePath)
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package fio
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilUnlocked(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, ofd)
+	unlockedAt := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlockedAt <- time.Now()
+		file.Close()
+	}()
+
+	WaitUntilUnlocked(context.Background(), filePath, ofd)
+	if time.Now().Before(<-unlockedAt) {
+		t.Fatal("returned before file was unlocked")
+	}
+}
+
+func TestWaitUntilUnlockedTimeout(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_RDWR, 0660, ofd)
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := TryWaitUntilUnlocked(ctx, filePath, ofd); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestWaitUntilStable(t *testing.T) {
+	filePath := createTestFile(t)
+	ofd := WithLocker(OFDLocker{})
+
+	file := OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0660, ofd)
+	if err := file.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	lastWriteAt := make(chan time.Time, 1)
+	go func() {
+		defer file.Close()
+		for i := 0; i < 5; i++ {
+			time.Sleep(30 * time.Millisecond)
+			file.Write([]byte(testData))
+		}
+		lastWriteAt <- time.Now()
+	}()
+
+	WaitUntilStable(context.Background(), filePath, 100*time.Millisecond, ofd)
+	if time.Now().Before((<-lastWriteAt).Add(100 * time.Millisecond)) {
+		t.Fatal("returned before file was stable")
+	}
+}
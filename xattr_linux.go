@@ -0,0 +1,94 @@
+package fio
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errNoXattr is the error of the xattr system calls for attributes which do not exist.
+const errNoXattr = syscall.ENODATA
+
+func listXattrsLocked(o *options, filePath string) ([]string, error) {
+	file, err := openFile(o, filePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(o, file)
+	return listXattrs(o, filePath)
+}
+
+func getXattrLocked(o *options, filePath, name string) ([]byte, error) {
+	file, err := openFile(o, filePath, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFile(o, file)
+	return getXattr(o, filePath, name)
+}
+
+func setXattrLocked(o *options, filePath, name string, value []byte) error {
+	file, err := openFile(o, filePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer closeFile(o, file)
+	return o.fs.Setxattr(filePath, name, value, 0)
+}
+
+func removeXattrLocked(o *options, filePath, name string) error {
+	file, err := openFile(o, filePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer closeFile(o, file)
+	return o.fs.Removexattr(filePath, name)
+}
+
+// listXattrs returns the names of all extended attributes of the file at filePath.
+func listXattrs(o *options, filePath string) ([]string, error) {
+	data, err := readXattrData(func(dest []byte) (int, error) {
+		return o.fs.Listxattr(filePath, dest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(data, []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of the extended attribute name of the file at filePath.
+func getXattr(o *options, filePath, name string) ([]byte, error) {
+	return readXattrData(func(dest []byte) (int, error) {
+		return o.fs.Getxattr(filePath, name, dest)
+	})
+}
+
+// readXattrData calls read with a buffer large enough for the data it returns. The buffer is
+// grown if the data changes between querying its size and reading it, as reported by ERANGE.
+func readXattrData(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		data := make([]byte, size)
+		n, err := read(data)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+}